@@ -0,0 +1,210 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parsePackageSpec parses a package definition in the form of name@version.
+// The version is optional and it will be empty when missing.
+func parsePackageSpec(spec string) (Package, error) {
+	name, version := spec, ""
+	if idx := strings.LastIndex(spec, "@"); idx != -1 {
+		name, version = spec[:idx], spec[idx+1:]
+		if version == "" {
+			return Package{}, fmt.Errorf("missing version for package: %s", spec)
+		}
+	}
+
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return Package{}, fmt.Errorf("missing package name: %s", spec)
+	}
+
+	return Package{Name: name, Version: version}, nil
+}
+
+// loadProject reads the manifest and lock files of the project. Missing files are
+// replaced with empty ones for the current package.
+func (d *Deep) loadProject(pwd, currentPkg string) (*Manifest, *Lock, error) {
+	manifest, err := readManifest(pwd)
+	if os.IsNotExist(err) {
+		manifest = &Manifest{
+			Package: Package{
+				Name:    currentPkg,
+				Version: "HEAD",
+			},
+		}
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	lock, err := readLock(pwd)
+	if os.IsNotExist(err) {
+		lock = &Lock{
+			Package: manifest.Package,
+		}
+		lock.Dependencies = nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	return manifest, lock, nil
+}
+
+// Add adds the package, defined as name@version, to the manifest then vendors
+// it along with the dependencies it needs that are not vendored yet.
+// The rest of the vendored packages are left untouched.
+func (d *Deep) Add(ctx context.Context, pwd, currentPkg string, keepTypes map[string]struct{}, spec string) error {
+	pkg, err := parsePackageSpec(spec)
+	if err != nil {
+		return err
+	}
+	if pkg.Version == "" {
+		pkg.Version = defaultVersion
+	}
+
+	return d.addPackage(ctx, pwd, currentPkg, keepTypes, pkg)
+}
+
+// Update vendors the package, defined as name@version, again. When the version
+// is missing, the version from the manifest is used.
+func (d *Deep) Update(ctx context.Context, pwd, currentPkg string, keepTypes map[string]struct{}, spec string) error {
+	pkg, err := parsePackageSpec(spec)
+	if err != nil {
+		return err
+	}
+
+	if pkg.Version == "" {
+		manifest, err := readManifest(pwd)
+		if err != nil {
+			return err
+		}
+
+		dep, ok := manifest.dependency(pkg.Name)
+		if !ok {
+			return newError(ErrPackageNotFound, pkg.Name, errors.New("the package is not in the manifest"))
+		}
+		pkg.Version = dep.Version
+	}
+
+	return d.addPackage(ctx, pwd, currentPkg, keepTypes, pkg)
+}
+
+func (d *Deep) addPackage(ctx context.Context, pwd, currentPkg string, keepTypes map[string]struct{}, pkg Package) error {
+	if currentPkg == "" {
+		return errors.New("current package is empty, are you running on a project from GOPATH?")
+	}
+
+	manifest, lock, err := d.loadProject(pwd, currentPkg)
+	if err != nil {
+		return err
+	}
+
+	err = d.begin(pwd)
+	if err != nil {
+		return err
+	}
+	defer d.rollback()
+
+	d.addKnownPackages(lock.Dependencies)
+	d.addKnownPackages(manifest.Dependencies)
+	pkg = d.rootPackage(pwd, pkg)
+
+	// Keep the source, the test and the strip settings of the package when it's
+	// already in the manifest
+	if dep, ok := manifest.dependency(pkg.Name); ok {
+		if pkg.Source == "" {
+			pkg.Source, pkg.VCS = dep.Source, dep.VCS
+		}
+		pkg.Tests = pkg.Tests || dep.Tests
+		if pkg.Strip == nil {
+			pkg.Strip = dep.Strip
+		}
+	}
+
+	r := newResolution(currentPkg, manifest, lock)
+	r.addExisting(manifest, pkg.Name)
+	r.require(currentPkg, pkg)
+	// The requested package is always resolved again, even if it's locked
+	r.packages[0].Tag, r.packages[0].CommitHash = "", ""
+
+	err = d.vendorResolution(ctx, pwd, currentPkg, r, func(idx int) bool { return idx == 0 })
+	if err != nil {
+		return err
+	}
+
+	r.markTestOnly()
+
+	if conflicts := d.conflicts(pwd, r); len(conflicts) > 0 {
+		return &ConflictError{Conflicts: conflicts}
+	}
+
+	for _, warning := range d.unneededOverrides(pwd, r) {
+		d.log("%s\n", warning)
+	}
+
+	// The project doesn't import the package yet, so pruning it now would leave
+	// nothing of it. The next run prunes it once the project imports it.
+	packages, err := d.processPackages(pwd, currentPkg, keepTypes, true, r.packages)
+	if err != nil {
+		return err
+	}
+
+	// The pruned packages must not be left in the lock
+	kept := map[string]struct{}{}
+	for _, pkg := range packages {
+		kept[pkg.Name] = struct{}{}
+	}
+	for _, pkg := range r.packages {
+		if _, ok := kept[pkg.Name]; !ok {
+			lock.removeDependency(pkg.Name)
+		}
+	}
+
+	for _, pkg := range packages {
+		lock.setDependency(pkg)
+		if !pkg.transitive {
+			manifest.setDependency(pkg)
+		}
+	}
+
+	err = manifest.writeFile(d.filesPath(pwd))
+	if err != nil {
+		return err
+	}
+
+	err = lock.writeFile(d.filesPath(pwd))
+	if err != nil {
+		return err
+	}
+
+	err = d.writeSums(pwd, lock.Dependencies)
+	if err != nil {
+		return err
+	}
+
+	// An interrupt while processing the packages still leaves everything untouched
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return d.commit(pwd)
+}
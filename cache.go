@@ -0,0 +1,317 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CacheEntry describes a repository mirrored in the shared cache
+type CacheEntry struct {
+	// Name is the repository root, e.g. github.com/dlsniper/deep
+	Name string
+	VCS  string
+	URL  string
+	Path string
+	// Size is the size of the mirror on disk, in bytes
+	Size int64
+}
+
+// repositoriesCacheDir returns the default directory of the shared cache
+func repositoriesCacheDir() string {
+	return filepath.Join(cacheDir(), "repositories")
+}
+
+// SetCacheDir sets the directory of the repositories shared between projects.
+// An empty directory disables the cache.
+func (d *Deep) SetCacheDir(dir string) {
+	d.cache = dir
+}
+
+// mirrorPath returns the path of the mirror of the repository in the cache
+func (d *Deep) mirrorPath(v vcs, name string) string {
+	return filepath.Join(d.cache, v.name(), filepath.FromSlash(name))
+}
+
+// cloneRepository clones the repository of the package to path. When the
+// version control system supports it, the repository is mirrored in the cache
+// first, or the mirror updated, then cloned from there.
+func (d *Deep) cloneRepository(ctx context.Context, v vcs, url string, pkg Package, path string, out io.Writer) error {
+	c, ok := v.(cachingVCS)
+	if !ok || d.cache == "" {
+		if d.offline {
+			return d.offlineError(pkg, "the repository can only be cloned from the network")
+		}
+		return v.clone(ctx, url, path, out)
+	}
+
+	mirrorPath, err := d.updateMirror(ctx, c, url, pkg, out)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.offline {
+			return err
+		}
+		// The cache only saves time, so the repository is still cloned without it
+		fmt.Fprintf(out, "Could not use the cache for %s: %v\n", pkg.Name, err)
+		return v.clone(ctx, url, path, out)
+	}
+
+	err = c.clone(ctx, mirrorPath, path, out)
+	if err != nil {
+		return err
+	}
+
+	return c.setOrigin(path, url)
+}
+
+// updateMirror creates or updates the mirror of the repository of the package
+// and returns its path. A mirror which already has the locked commit of the
+// package is used as it is. In offline mode, the mirror is never updated.
+func (d *Deep) updateMirror(ctx context.Context, c cachingVCS, url string, pkg Package, out io.Writer) (string, error) {
+	path := d.mirrorPath(c, pkg.Name)
+	if c.isMirror(path) {
+		if pkg.CommitHash != "" && c.hasRevision(path, pkg.CommitHash) {
+			return path, nil
+		}
+		if d.offline {
+			if pkg.CommitHash != "" {
+				return "", d.offlineError(pkg, "the commit is not in the cache")
+			}
+			return path, nil
+		}
+		return path, c.fetch(ctx, url, path, out)
+	}
+
+	if d.offline {
+		return "", d.offlineError(pkg, "the repository is not in the cache")
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	// The mirror is created aside so that an interrupted one is never used
+	tmp, err := ioutil.TempDir(filepath.Dir(path), ".mirror")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	tmpPath := filepath.Join(tmp, filepath.Base(path))
+	err = c.mirror(ctx, url, tmpPath, out)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil && !c.isMirror(path) {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// rootFromCache finds the repository root of the import path among the
+// repositories of the cache
+func (d *Deep) rootFromCache(importPath string) (repoRoot, bool) {
+	if d.cache == "" {
+		return repoRoot{}, false
+	}
+
+	for path := importPath; path != ""; path = importPathDir(path) {
+		for _, v := range vcsList {
+			c, ok := v.(cachingVCS)
+			if !ok || !c.isMirror(d.mirrorPath(c, path)) {
+				continue
+			}
+
+			url, _ := c.origin(d.mirrorPath(c, path))
+			return repoRoot{
+				root: path,
+				vcs:  c.name(),
+				url:  url,
+			}, true
+		}
+	}
+
+	return repoRoot{}, false
+}
+
+// CacheEntries returns the repositories mirrored in the cache, sorted by name
+func (d *Deep) CacheEntries() ([]CacheEntry, error) {
+	if d.cache == "" {
+		return nil, nil
+	}
+
+	var entries []CacheEntry
+	for _, v := range vcsList {
+		c, ok := v.(cachingVCS)
+		if !ok {
+			continue
+		}
+
+		root := filepath.Join(d.cache, c.name())
+		err := filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == root {
+					return nil
+				}
+				return err
+			}
+			if !f.IsDir() || path == root {
+				return nil
+			}
+			// Mirrors still being created are not listed
+			if strings.HasPrefix(f.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !c.isMirror(path) {
+				return nil
+			}
+
+			name, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			url, _ := c.origin(path)
+			size, err := dirSize(path)
+			if err != nil {
+				return err
+			}
+
+			entries = append(entries, CacheEntry{
+				Name: filepath.ToSlash(name),
+				VCS:  c.name(),
+				URL:  url,
+				Path: path,
+				Size: size,
+			})
+			return filepath.SkipDir
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// cacheEntries returns the mirrors of the named repositories, or all of them
+// when no name is given
+func (d *Deep) cacheEntries(names []string) ([]CacheEntry, error) {
+	entries, err := d.CacheEntries()
+	if err != nil || len(names) == 0 {
+		return entries, err
+	}
+
+	var result []CacheEntry
+	for _, name := range names {
+		found := false
+		for _, entry := range entries {
+			if entry.Name == name {
+				result = append(result, entry)
+				found = true
+			}
+		}
+		if !found {
+			return nil, newError(ErrPackageNotFound, name, errors.New("the repository is not in the cache"))
+		}
+	}
+	return result, nil
+}
+
+// VerifyCache checks the integrity of the named repositories in the cache, or
+// of all of them when no name is given. The result for each of them is logged.
+func (d *Deep) VerifyCache(ctx context.Context, names ...string) error {
+	entries, err := d.cacheEntries(names)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, entry := range entries {
+		v, _ := vcsByName(entry.VCS)
+		err := v.(cachingVCS).verify(ctx, entry.Path)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			d.log("%s: %v\n", entry.Name, err)
+			failed++
+			continue
+		}
+		d.log("%s: ok\n", entry.Name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of the %d cached repositories are corrupted, remove them with deep cache clean", failed, len(entries))
+	}
+	return nil
+}
+
+// CleanCache removes the named repositories from the cache, or all of them
+// when no name is given
+func (d *Deep) CleanCache(names ...string) error {
+	if d.cache == "" {
+		return nil
+	}
+
+	if len(names) == 0 {
+		return os.RemoveAll(d.cache)
+	}
+
+	entries, err := d.cacheEntries(names)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		err := os.RemoveAll(entry.Path)
+		if err != nil {
+			return err
+		}
+		d.removeEmptyParents(filepath.Join(d.cache, entry.VCS), entry.Path)
+		d.log("Removed %s from the cache\n", entry.Name)
+	}
+	return nil
+}
+
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !f.IsDir() {
+			size += f.Size()
+		}
+		return nil
+	})
+	return size, err
+}
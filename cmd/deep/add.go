@@ -0,0 +1,49 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+)
+
+var addCmd = &cobra.Command{
+	Use:   "add package[@version]",
+	Short: "Add a package to the project",
+	Long: `Add a package to the manifest and vendor it at the requested version.
+
+Only the package and the dependencies it needs which are not vendored yet are
+vendored, the rest of the vendored packages are left untouched.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("add requires exactly one package[@version]")
+		}
+
+		pwd, currentPkg, keep, err := project(cmd)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := interruptContext()
+		defer cancel()
+
+		return newDeep().Add(ctx, pwd, currentPkg, keep, args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(addCmd)
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the repositories shared between projects",
+	Long: `The repositories of the vendored packages are mirrored in a cache shared
+between projects, in $XDG_CACHE_HOME/deep/repositories by default, so that
+they are only fetched again when needed.`,
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the cached repositories",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := newDeep().CacheEntries()
+		if err != nil {
+			return err
+		}
+
+		var total int64
+		for _, entry := range entries {
+			fmt.Printf("%s\t%s\t%s\t%d\n", entry.Name, entry.VCS, entry.URL, entry.Size)
+			total += entry.Size
+		}
+		fmt.Printf("%d repositories, %d bytes\n", len(entries), total)
+		return nil
+	},
+}
+
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify [repository...]",
+	Short: "Check the integrity of the cached repositories",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := interruptContext()
+		defer cancel()
+
+		return newDeep().VerifyCache(ctx, args...)
+	},
+}
+
+var cacheCleanCmd = &cobra.Command{
+	Use:   "clean [repository...]",
+	Short: "Remove the given repositories, or all of them, from the cache",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return newDeep().CleanCache(args...)
+	},
+}
+
+func init() {
+	cacheCmd.AddCommand(cacheListCmd, cacheVerifyCmd, cacheCleanCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,292 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command deep is the command line interface for the deep package manager
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/build"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/dlsniper/deep"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// version is injected at build time via -ldflags "-X main.version=..."
+var version = "dev"
+
+var (
+	keepFlag     []string
+	stripFlag    []string
+	testDepsFlag []string
+	yesFlag      bool
+	noFlag       bool
+	jobsFlag     int
+	noCacheFlag  bool
+	offlineFlag  bool
+
+	logger = log.New(os.Stderr, "", 0)
+)
+
+// allTypes lists the types of files understood by the library which can be
+// kept or stripped from the vendored packages
+var allTypes = []string{"vcs", "test", "main", "examples", "testdata", "docs", "assets", "unused"}
+
+// flagTypes maps the values accepted by --keep and --strip to the types of
+// files the library understands
+var flagTypes = map[string][]string{
+	"none":     {},
+	"vcs":      {"vcs"},
+	"tests":    {"test"},
+	"main":     {"main"},
+	"examples": {"examples"},
+	"testdata": {"testdata"},
+	"docs":     {"docs"},
+	"assets":   {"assets"},
+	"unused":   {"unused"},
+	"all":      allTypes,
+}
+
+var rootCmd = &cobra.Command{
+	Use:   "deep [package@version]",
+	Short: "A shallow package manager for Go",
+	Long: `deep vendors the dependencies of the Go project in the current directory.
+
+Without arguments it vendors all the dependencies of the project. When a
+package@version is given, it is the same as running deep add package@version.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// The packages given as arguments are handled by deep add
+		if len(args) > 0 {
+			return fmt.Errorf("unknown command %q for %q", args[0], cmd.CommandPath())
+		}
+
+		pwd, currentPkg, keep, err := project(cmd)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := interruptContext()
+		defer cancel()
+
+		return newDeep().Run(ctx, pwd, currentPkg, keep)
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringSliceVar(&keepFlag, "keep", nil, "types of files to keep in vendored packages: none, vcs, tests, main, examples, testdata, docs, assets, unused, all (default from the manifest or assets)")
+	rootCmd.PersistentFlags().StringSliceVar(&stripFlag, "strip", nil, "types of files to strip from vendored packages: vcs, tests, main, examples, testdata, docs, assets, unused, all (default from the manifest or all but assets)")
+	rootCmd.PersistentFlags().BoolVarP(&yesFlag, "yes", "y", false, "replace the already vendored packages without asking")
+	rootCmd.PersistentFlags().BoolVarP(&noFlag, "no", "n", false, "never replace the already vendored packages")
+	rootCmd.PersistentFlags().IntVarP(&jobsFlag, "jobs", "j", 0, "maximum number of packages fetched at the same time (default the number of CPUs)")
+	rootCmd.PersistentFlags().BoolVar(&noCacheFlag, "no-cache", false, "clone the repositories directly instead of through the shared cache")
+	rootCmd.PersistentFlags().BoolVar(&offlineFlag, "offline", false, "never access the network, vendor the packages from the lock file and the shared cache only")
+	rootCmd.PersistentFlags().StringSliceVar(&testDepsFlag, "test-deps", nil, "packages for which the test dependencies are vendored, or all. Requires --keep tests")
+}
+
+// newDeep creates the library instance configured from the flags
+func newDeep() *deep.Deep {
+	d := deep.New(logger.Printf)
+	d.IncludeTestDependencies(testDepsFlag...)
+	d.SetJobs(jobsFlag)
+	if noCacheFlag {
+		d.SetCacheDir("")
+	}
+	d.SetOffline(offlineFlag)
+	switch {
+	case yesFlag:
+		d.SetWipePolicy(deep.WipeAlways)
+	case noFlag:
+		d.SetWipePolicy(deep.WipeNever)
+	}
+	return d
+}
+
+// interruptContext returns a context which is canceled on the first interrupt,
+// so that the fetches in progress are stopped and the vendor directory is left
+// untouched. A second interrupt terminates the program right away.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		select {
+		case <-signals:
+			logger.Println("Interrupted, stopping")
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// keepTypes computes the types of files to keep in the vendored packages
+// based on the --keep and --strip flags. Without them, nil is returned so
+// that the manifest decides.
+func keepTypes(cmd *cobra.Command) (map[string]struct{}, error) {
+	keepChanged := cmd.Flags().Changed("keep")
+	stripChanged := cmd.Flags().Changed("strip")
+	if keepChanged && stripChanged {
+		return nil, errors.New("--keep and --strip cannot be used together")
+	}
+	if !keepChanged && !stripChanged {
+		return nil, nil
+	}
+
+	result := map[string]struct{}{}
+	if keepChanged {
+		for _, value := range keepFlag {
+			types, ok := flagTypes[value]
+			if !ok {
+				return nil, fmt.Errorf("unknown --keep value: %s", value)
+			}
+			for _, typ := range types {
+				result[typ] = struct{}{}
+			}
+		}
+		return result, nil
+	}
+
+	for _, typ := range allTypes {
+		result[typ] = struct{}{}
+	}
+	for _, value := range stripFlag {
+		types, ok := flagTypes[value]
+		if !ok || value == "none" {
+			return nil, fmt.Errorf("unknown --strip value: %s", value)
+		}
+		for _, typ := range types {
+			delete(result, typ)
+		}
+	}
+
+	return result, nil
+}
+
+// currentPackage returns the import path of the directory based on GOPATH
+// or an empty string if the directory is not in GOPATH
+func currentPackage(pwd string) string {
+	gopath := os.Getenv("GOPATH")
+	if gopath == "" {
+		gopath = build.Default.GOPATH
+	}
+
+	for _, path := range filepath.SplitList(gopath) {
+		src := filepath.Join(path, "src")
+		if pkg := relativeImportPath(src, pwd); pkg != "" {
+			return pkg
+		}
+		if pkg := relativeImportPath(evalSymlinks(src), evalSymlinks(pwd)); pkg != "" {
+			return pkg
+		}
+	}
+
+	return ""
+}
+
+func relativeImportPath(src, pwd string) string {
+	src += string(os.PathSeparator)
+	if !strings.HasPrefix(pwd, src) {
+		return ""
+	}
+
+	return filepath.ToSlash(strings.TrimPrefix(pwd, src))
+}
+
+func evalSymlinks(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// project returns the directory, the import path and the types of files to keep
+// for the project in the current directory
+func project(cmd *cobra.Command) (pwd, currentPkg string, keep map[string]struct{}, err error) {
+	if yesFlag && noFlag {
+		return "", "", nil, errors.New("--yes and --no cannot be used together")
+	}
+
+	keep, err = keepTypes(cmd)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	pwd, err = os.Getwd()
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	return pwd, currentPackage(pwd), keep, nil
+}
+
+// firstArg returns the first argument which is neither a flag nor the value of one
+func firstArg(flags *pflag.FlagSet, args []string) string {
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		switch {
+		case arg == "--":
+			if idx+1 < len(args) {
+				return args[idx+1]
+			}
+			return ""
+		case !strings.HasPrefix(arg, "-") || arg == "-":
+			return arg
+		case strings.Contains(arg, "="):
+			continue
+		}
+
+		var flag *pflag.Flag
+		if strings.HasPrefix(arg, "--") {
+			flag = flags.Lookup(arg[2:])
+		} else if len(arg) == 2 {
+			flags.VisitAll(func(f *pflag.Flag) {
+				if f.Shorthand == arg[1:] {
+					flag = f
+				}
+			})
+		}
+		// The value of the flag is the next argument
+		if flag != nil && flag.NoOptDefVal == "" {
+			idx++
+		}
+	}
+	return ""
+}
+
+func main() {
+	// deep package@version is a shorthand for deep add package@version. Only
+	// import paths are added, so that a mistyped command is reported as such.
+	args := os.Args[1:]
+	if cmd, _, err := rootCmd.Find(args); err != nil && cmd == rootCmd {
+		if strings.ContainsAny(firstArg(rootCmd.PersistentFlags(), args), "/@") {
+			rootCmd.SetArgs(append([]string{addCmd.Name()}, args...))
+		}
+	}
+
+	if err := rootCmd.Execute(); err != nil {
+		logger.Println(err)
+		os.Exit(1)
+	}
+}
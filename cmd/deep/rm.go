@@ -0,0 +1,50 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+)
+
+var rmCmd = &cobra.Command{
+	Use:   "rm <package>",
+	Short: "Remove a vendored package",
+	Long: `Remove a package from the manifest and lock files and delete its vendored copy.
+A package inside a repository removes the whole repository.
+
+The package is not removed while the project or other vendored packages still
+import it, unless --force is used.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("rm requires exactly one package")
+		}
+
+		pwd, currentPkg, _, err := project(cmd)
+		if err != nil {
+			return err
+		}
+
+		return newDeep().Remove(pwd, currentPkg, args[0], forceRemove)
+	},
+}
+
+var forceRemove bool
+
+func init() {
+	rmCmd.Flags().BoolVar(&forceRemove, "force", false, "remove the package even if it is still imported")
+	rootCmd.AddCommand(rmCmd)
+}
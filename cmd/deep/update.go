@@ -0,0 +1,48 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+)
+
+var updateCmd = &cobra.Command{
+	Use:   "update package[@version]",
+	Short: "Update a vendored package",
+	Long: `Update a vendored package to the requested version.
+
+When no version is given, the version from the manifest is used.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("update requires exactly one package[@version]")
+		}
+
+		pwd, currentPkg, keep, err := project(cmd)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := interruptContext()
+		defer cancel()
+
+		return newDeep().Update(ctx, pwd, currentPkg, keep, args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(updateCmd)
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that the vendored packages match the lock file",
+	Long: `Check that the content of the vendored packages matches the digests of the
+lock file and report the files added, removed or modified in each of them.
+
+The command fails when the vendor directory does not match the lock file.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pwd, _, _, err := project(cmd)
+		if err != nil {
+			return err
+		}
+
+		diffs, err := newDeep().Verify(pwd)
+		if err != nil {
+			return err
+		}
+
+		for _, diff := range diffs {
+			name := diff.Package
+			if name == "" {
+				name = "vendor"
+			}
+
+			if diff.Missing {
+				fmt.Printf("%s: not vendored\n", name)
+				continue
+			}
+			if len(diff.Added)+len(diff.Removed)+len(diff.Modified) == 0 {
+				fmt.Printf("%s: content differs from the lock\n", name)
+				continue
+			}
+			for _, file := range diff.Added {
+				fmt.Printf("%s: added %s\n", name, file)
+			}
+			for _, file := range diff.Removed {
+				fmt.Printf("%s: removed %s\n", name, file)
+			}
+			for _, file := range diff.Modified {
+				fmt.Printf("%s: modified %s\n", name, file)
+			}
+		}
+
+		if len(diffs) > 0 {
+			return errors.New("the vendor directory does not match the lock file")
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+}
@@ -17,9 +17,10 @@ docker run --rm \
         --net="host" \
         -v ${PROJECT_DIR}:${CONTAINER_PROJECT_DIR} \
         -e GOPATH=${CONTAINER_PROJECT_GOPATH} \
+        -e GO111MODULE=off \
         -e CGO_ENABLED=0 \
         -w "${CONTAINER_PROJECT_DIR}" \
-        golang:1.8-alpine \
+        golang:1.16-alpine \
         go build -v -tags netgo -installsuffix netgo -ldflags "-X main.version=${CONTAINER_TAG}" -o deep ${MAIN_PACKAGE}
 
 docker build -f ${PROJECT_DIR}/Dockerfile \
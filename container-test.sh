@@ -15,8 +15,9 @@ docker run --rm \
     -v ${PROJECT_DIR}:${CONTAINER_PROJECT_DIR} \
     -e CI=true \
     -e GOPATH=${CONTAINER_PROJECT_GOPATH} \
+    -e GO111MODULE=off \
     -w "${CONTAINER_PROJECT_DIR}" \
-    golang:1.8 \
+    golang:1.16 \
     go test -v -race -tags netgo -installsuffix netgo ./... 2> output.log
 
 EXIT_CODE=$?
@@ -14,22 +14,74 @@
 
 package deep
 
-import "errors"
+import "os"
 
 type deep struct {
-		logger Logger
-	}
+	logger Logger
+}
 
 func (d *deep) canUse(pwd, currentPkg string) bool {
-	return false
+	_, err := os.Stat(pwd + pathSeparatorString + manifestFileName)
+	return err == nil
 }
 
+// packages returns the dependencies from the manifest file of the project
 func (d *deep) packages(pwd, currentPkg string) ([]Package, error) {
-	return nil, errors.New("not implemented yet")
+	return lockedDependencies(pwd)
 }
 
-func (d *deep) loadFiles() (*Manifest, *Lock, error) {
-	return nil, nil, nil
+// lockedDependencies returns the dependencies from the manifest file found in the
+// directory, if any. When the lock file has the same version for a dependency then
+// its commit hash is used as well so that the exact same tree is reproduced,
+// otherwise the dependency is resolved again.
+func lockedDependencies(path string) ([]Package, error) {
+	manifest, lock, err := newDeep(nil).loadFiles(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	locked := map[string]Package{}
+	if lock != nil {
+		for _, pkg := range lock.Dependencies {
+			locked[pkg.Name] = pkg
+		}
+	}
+
+	var result []Package
+	for _, pkg := range manifest.Dependencies {
+		pkg.Tag, pkg.CommitHash = "", ""
+		pkg.Dependencies = nil
+		if lockedPkg, ok := locked[pkg.Name]; ok && lockedPkg.Version == pkg.Version {
+			pkg.Tag = lockedPkg.Tag
+			pkg.CommitHash = lockedPkg.CommitHash
+		}
+
+		result = append(result, pkg)
+	}
+
+	return result, nil
+}
+
+// loadFiles reads the manifest and the lock file from the project directory.
+// The lock file is optional, in which case it will be nil.
+func (d *deep) loadFiles(pwd string) (*Manifest, *Lock, error) {
+	manifest, err := readManifest(pwd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lock, err := readLock(pwd)
+	if os.IsNotExist(err) {
+		return manifest, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return manifest, lock, nil
 }
 
 func newDeep(logger Logger) *deep {
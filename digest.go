@@ -0,0 +1,372 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sumFileName is the file of the vendor directory holding the hashes of the
+// files of each vendored package, used to tell which files changed
+const sumFileName = ".deep_sum"
+
+// TreeDiff describes how the vendored copy of a package differs from the lock.
+// Without the hashes of the locked files, only the digest is known to differ
+// and the lists of files are empty.
+type TreeDiff struct {
+	// Package is the name of the package, empty for the files of the vendor
+	// directory which are not part of any locked package
+	Package string
+	// Missing is true when the package is not vendored
+	Missing  bool
+	Added    []string
+	Removed  []string
+	Modified []string
+}
+
+// packageRoots returns the vendored paths of the packages
+func (d *Deep) packageRoots(pwd string, packages []Package) map[string]struct{} {
+	roots := map[string]struct{}{}
+	for _, pkg := range packages {
+		roots[d.vendoredPath(pwd, pkg)] = struct{}{}
+	}
+	return roots
+}
+
+// packageFiles returns the hashes of the files of the vendored package, by
+// their slash separated path relative to the package. The version control
+// metadata and the packages nested in it, found in roots, are left out.
+func (d *Deep) packageFiles(pwd string, pkg Package, roots map[string]struct{}) (map[string]string, error) {
+	root := d.vendoredPath(pwd, pkg)
+	files := map[string]string{}
+	err := filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() {
+			if path == root {
+				return nil
+			}
+			if _, ok := roots[path]; ok {
+				return filepath.SkipDir
+			}
+			for _, vcsDir := range d.vcsDirs {
+				if f.Name() == vcsDir {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+
+		hash, err := fileHash(path, f)
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(name)] = hash
+		return nil
+	})
+
+	return files, err
+}
+
+// fileHash returns the sha256 of the file content or, for symbolic links, of
+// their target
+func fileHash(path string, f os.FileInfo) (string, error) {
+	h := sha256.New()
+	if f.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(h, "symlink:"+target)
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// treeDigest returns the digest of the files of a package. It only depends on
+// the names and the content of the files, so it's the same on every machine.
+func treeDigest(files map[string]string) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s  %s\n", files[name], name)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// digestPackages computes the digest of the vendored packages, once they are
+// stripped, and keeps the hashes of their files for the sum file
+func (d *Deep) digestPackages(pwd string, packages []Package) {
+	roots := d.packageRoots(pwd, packages)
+	for idx, pkg := range packages {
+		files, err := d.packageFiles(pwd, pkg, roots)
+		if err != nil {
+			d.log("Error while computing the digest of %s: %v\n", pkg.Name, err)
+			continue
+		}
+
+		packages[idx].Digest = treeDigest(files)
+		packages[idx].files = files
+	}
+}
+
+// checkKeptPackages checks that the packages which were not vendored again,
+// as found before being stripped again, still match the digest of the lock.
+// Otherwise the lock would record the local changes as the expected content.
+func (d *Deep) checkKeptPackages(pwd string, packages []Package) error {
+	lock, err := readLock(pwd)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	roots := d.packageRoots(pwd, lock.Dependencies)
+	for _, pkg := range packages {
+		if pkg.fetched {
+			continue
+		}
+		locked, ok := lock.dependency(pkg.Name)
+		if !ok || locked.Digest == "" {
+			continue
+		}
+
+		files, err := d.packageFiles(pwd, pkg, roots)
+		if err != nil {
+			return err
+		}
+		if treeDigest(files) != locked.Digest {
+			return &Error{
+				Kind:    ErrDigestMismatch,
+				Package: pkg.Name,
+				Err:     errors.New("the vendored copy was changed, replace it or run deep verify to see the changes"),
+			}
+		}
+	}
+
+	return nil
+}
+
+// readSums reads the hashes of the files of the packages from the sum file
+// of the vendor directory
+func readSums(vendorPath string) (map[string]map[string]string, error) {
+	file, err := os.Open(filepath.Join(vendorPath, sumFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sums := map[string]map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Each line looks like: <package> <sha256> <file>
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if sums[fields[0]] == nil {
+			sums[fields[0]] = map[string]string{}
+		}
+		sums[fields[0]][fields[2]] = fields[1]
+	}
+
+	return sums, scanner.Err()
+}
+
+// writeSums writes the hashes of the files of the packages to the sum file of
+// the vendor directory. The packages which were not vendored again keep their
+// previous hashes.
+func (d *Deep) writeSums(pwd string, packages []Package) error {
+	vendorPath := d.vendorPath(pwd)
+	if exists, err := d.pathExists(vendorPath); err != nil || !exists {
+		return err
+	}
+
+	previous, _ := readSums(vendorPath)
+	var content bytes.Buffer
+	for _, pkg := range packages {
+		files := pkg.files
+		if files == nil {
+			files = previous[pkg.Name]
+		}
+
+		names := make([]string, 0, len(files))
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&content, "%s %s %s\n", pkg.Name, files[name], name)
+		}
+	}
+
+	// The vendor directory being prepared shares its files with the current one,
+	// so the sum file is replaced instead of being written over
+	path := filepath.Join(vendorPath, sumFileName)
+	err := ioutil.WriteFile(path+".tmp", content.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Verify checks that the vendored packages match the digests of the lock file
+// and returns the differences found. The files of the vendor directory which
+// are not part of any locked package are reported as well.
+func (d *Deep) Verify(pwd string) ([]TreeDiff, error) {
+	lock, err := readLock(pwd)
+	if err != nil {
+		return nil, err
+	}
+
+	vendorPath := d.vendorPath(pwd)
+	sums, _ := readSums(vendorPath)
+	roots := d.packageRoots(pwd, lock.Dependencies)
+
+	var result []TreeDiff
+	for _, pkg := range lock.Dependencies {
+		if pkg.Digest == "" {
+			d.log("No digest locked for %s, vendor it again to record one\n", pkg.Name)
+			continue
+		}
+
+		exists, err := d.pathExists(d.vendoredPath(pwd, pkg))
+		if err != nil {
+			return nil, err
+		}
+		// A package with all its files stripped may not be vendored at all
+		if !exists {
+			if pkg.Digest != treeDigest(nil) {
+				result = append(result, TreeDiff{Package: pkg.Name, Missing: true})
+			}
+			continue
+		}
+
+		files, err := d.packageFiles(pwd, pkg, roots)
+		if err != nil {
+			return nil, err
+		}
+		if treeDigest(files) == pkg.Digest {
+			continue
+		}
+
+		diff := TreeDiff{Package: pkg.Name}
+		// The sum file can only tell which files changed when it matches the lock
+		if locked, ok := sums[pkg.Name]; ok && treeDigest(locked) == pkg.Digest {
+			diff.Added, diff.Removed, diff.Modified = compareFiles(locked, files)
+		}
+		result = append(result, diff)
+	}
+
+	extra, err := d.unlockedFiles(vendorPath, roots)
+	if err != nil {
+		return nil, err
+	}
+	if len(extra) > 0 {
+		result = append(result, TreeDiff{Added: extra})
+	}
+
+	return result, nil
+}
+
+// compareFiles returns the sorted files added, removed and modified compared
+// to the locked ones
+func compareFiles(locked, files map[string]string) (added, removed, modified []string) {
+	for name, hash := range files {
+		lockedHash, ok := locked[name]
+		switch {
+		case !ok:
+			added = append(added, name)
+		case lockedHash != hash:
+			modified = append(modified, name)
+		}
+	}
+	for name := range locked {
+		if _, ok := files[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(modified)
+	return added, removed, modified
+}
+
+// unlockedFiles returns the files of the vendor directory which are not part
+// of any of the packages, by their slash separated path relative to it
+func (d *Deep) unlockedFiles(vendorPath string, roots map[string]struct{}) ([]string, error) {
+	var result []string
+	err := filepath.Walk(vendorPath, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == vendorPath {
+				return nil
+			}
+			return err
+		}
+		if f.IsDir() {
+			if _, ok := roots[path]; ok {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if path == filepath.Join(vendorPath, sumFileName) {
+			return nil
+		}
+
+		name, err := filepath.Rel(vendorPath, path)
+		if err != nil {
+			return err
+		}
+		result = append(result, filepath.ToSlash(name))
+		return nil
+	})
+
+	return result, err
+}
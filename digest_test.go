@@ -0,0 +1,279 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// digestTestFiles is the vendor directory used by the digest tests
+var digestTestFiles = map[string]string{
+	"example.com/lib/lib.go":           "package lib\n",
+	"example.com/lib/sub/sub.go":       "package sub\n",
+	"example.com/lib/.git/HEAD":        "ref: refs/heads/master\n",
+	"example.com/lib/sub/.hg/store":    "store\n",
+	"example.com/lib/nested/nested.go": "package nested\n",
+	"example.com/util/util.go":         "package util\n",
+}
+
+// digestTestPackages are the packages of digestTestFiles, with
+// example.com/lib/nested being a repository of its own
+var digestTestPackages = []Package{
+	{Name: "example.com/lib"},
+	{Name: "example.com/lib/nested"},
+	{Name: "example.com/util"},
+}
+
+func TestPackageFiles(t *testing.T) {
+	pwd, err := ioutil.TempDir("", "deep-digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(pwd)
+
+	writeTestFiles(t, filepath.Join(pwd, "vendor"), digestTestFiles)
+
+	d := New(func(string, ...interface{}) {})
+	roots := d.packageRoots(pwd, digestTestPackages)
+	tests := []struct {
+		name  string
+		roots map[string]struct{}
+		want  []string
+	}{
+		// The version control metadata is left out and so are the nested roots
+		{name: "example.com/lib", roots: roots, want: []string{"lib.go", "sub/sub.go"}},
+		{name: "example.com/lib", roots: nil, want: []string{"lib.go", "nested/nested.go", "sub/sub.go"}},
+		{name: "example.com/lib/nested", roots: roots, want: []string{"nested.go"}},
+		{name: "example.com/util", roots: roots, want: []string{"util.go"}},
+	}
+
+	for _, tt := range tests {
+		files, err := d.packageFiles(pwd, Package{Name: tt.name}, tt.roots)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+
+		var names []string
+		for name, hash := range files {
+			names = append(names, name)
+			if want := sha256Hex(digestTestFiles[tt.name+"/"+name]); hash != want {
+				t.Errorf("%s: %s: got hash %s, want %s", tt.name, name, hash, want)
+			}
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("%s: got files %v, want %v", tt.name, names, tt.want)
+		}
+	}
+}
+
+func TestTreeDigest(t *testing.T) {
+	// The digest only depends on the names and the content of the files, so
+	// two copies of the vendor directory have the same digests
+	var digests []map[string]string
+	for run := 0; run < 2; run++ {
+		pwd, err := ioutil.TempDir("", "deep-digest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(pwd)
+
+		writeTestFiles(t, filepath.Join(pwd, "vendor"), digestTestFiles)
+
+		d := New(func(string, ...interface{}) {})
+		packages := append([]Package(nil), digestTestPackages...)
+		d.digestPackages(pwd, packages)
+
+		result := map[string]string{}
+		for _, pkg := range packages {
+			result[pkg.Name] = pkg.Digest
+		}
+		digests = append(digests, result)
+	}
+	if !reflect.DeepEqual(digests[0], digests[1]) {
+		t.Errorf("got the digests %v then %v", digests[0], digests[1])
+	}
+
+	files := map[string]string{"a.go": "1", "b/b.go": "2"}
+	tests := []struct {
+		files map[string]string
+		same  bool
+	}{
+		{files: map[string]string{"b/b.go": "2", "a.go": "1"}, same: true},
+		{files: map[string]string{"a.go": "1", "b/b.go": "3"}},
+		{files: map[string]string{"a.go": "1", "c/b.go": "2"}},
+		{files: map[string]string{"a.go": "1"}},
+		{files: nil},
+	}
+
+	digest := treeDigest(files)
+	for _, tt := range tests {
+		if got := treeDigest(tt.files) == digest; got != tt.same {
+			t.Errorf("%v: got the same digest %v, want %v", tt.files, got, tt.same)
+		}
+	}
+}
+
+func TestCompareFiles(t *testing.T) {
+	locked := map[string]string{"a.go": "1", "b.go": "2", "c.go": "3"}
+	tests := []struct {
+		files    map[string]string
+		added    []string
+		removed  []string
+		modified []string
+	}{
+		{files: map[string]string{"a.go": "1", "b.go": "2", "c.go": "3"}},
+		{
+			files: map[string]string{"a.go": "1", "b.go": "2", "c.go": "3", "e.go": "5", "d.go": "4"},
+			added: []string{"d.go", "e.go"},
+		},
+		{
+			files:   map[string]string{"b.go": "2"},
+			removed: []string{"a.go", "c.go"},
+		},
+		{
+			files:    map[string]string{"a.go": "9", "b.go": "2", "d.go": "4"},
+			added:    []string{"d.go"},
+			removed:  []string{"c.go"},
+			modified: []string{"a.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		added, removed, modified := compareFiles(locked, tt.files)
+		if !reflect.DeepEqual(added, tt.added) || !reflect.DeepEqual(removed, tt.removed) || !reflect.DeepEqual(modified, tt.modified) {
+			t.Errorf("%v: got %v, %v, %v, want %v, %v, %v", tt.files, added, removed, modified, tt.added, tt.removed, tt.modified)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, vendorPath string)
+		want   []TreeDiff
+	}{
+		{
+			name:   "unchanged",
+			change: func(t *testing.T, vendorPath string) {},
+		},
+		{
+			name: "vcs metadata",
+			change: func(t *testing.T, vendorPath string) {
+				writeTestFiles(t, vendorPath, map[string]string{"example.com/lib/.git/HEAD": "changed"})
+			},
+		},
+		{
+			name: "modified package",
+			change: func(t *testing.T, vendorPath string) {
+				writeTestFiles(t, vendorPath, map[string]string{
+					"example.com/lib/lib.go": "package lib\n\nvar changed = true\n",
+					"example.com/lib/new.go": "package lib\n",
+				})
+				removeTestFile(t, vendorPath, "example.com/lib/sub/sub.go")
+			},
+			want: []TreeDiff{{
+				Package:  "example.com/lib",
+				Added:    []string{"new.go"},
+				Removed:  []string{"sub/sub.go"},
+				Modified: []string{"lib.go"},
+			}},
+		},
+		{
+			name: "nested package",
+			change: func(t *testing.T, vendorPath string) {
+				writeTestFiles(t, vendorPath, map[string]string{"example.com/lib/nested/nested.go": "package changed\n"})
+			},
+			want: []TreeDiff{{Package: "example.com/lib/nested", Modified: []string{"nested.go"}}},
+		},
+		{
+			name: "missing package",
+			change: func(t *testing.T, vendorPath string) {
+				removeTestFile(t, vendorPath, "example.com/util")
+			},
+			want: []TreeDiff{{Package: "example.com/util", Missing: true}},
+		},
+		{
+			name: "unlocked files",
+			change: func(t *testing.T, vendorPath string) {
+				writeTestFiles(t, vendorPath, map[string]string{
+					"example.com/other/other.go": "package other\n",
+					"notes.txt":                  "notes\n",
+				})
+			},
+			want: []TreeDiff{{Added: []string{"example.com/other/other.go", "notes.txt"}}},
+		},
+		{
+			name: "without sums",
+			change: func(t *testing.T, vendorPath string) {
+				removeTestFile(t, vendorPath, sumFileName)
+				writeTestFiles(t, vendorPath, map[string]string{"example.com/util/util.go": "package changed\n"})
+			},
+			want: []TreeDiff{{Package: "example.com/util"}},
+		},
+	}
+
+	for _, tt := range tests {
+		pwd, err := ioutil.TempDir("", "deep-digest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(pwd)
+
+		vendorPath := filepath.Join(pwd, "vendor")
+		writeTestFiles(t, vendorPath, digestTestFiles)
+
+		d := New(func(string, ...interface{}) {})
+		lock := &Lock{Package: Package{Name: "example.com/app"}}
+		lock.Dependencies = append([]Package(nil), digestTestPackages...)
+		d.digestPackages(pwd, lock.Dependencies)
+		if err := lock.writeFile(pwd); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.writeSums(pwd, lock.Dependencies); err != nil {
+			t.Fatal(err)
+		}
+
+		tt.change(t, vendorPath)
+
+		got, err := d.Verify(pwd)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func removeTestFile(t *testing.T, dir, name string) {
+	if err := os.RemoveAll(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,339 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type (
+	// repoRoot describes the repository which holds an import path
+	repoRoot struct {
+		// root is the import path of the repository root
+		root string
+		vcs  string
+		url  string
+	}
+
+	// metaImport is the content of a go-import meta tag
+	metaImport struct {
+		prefix, vcs, repoRoot string
+	}
+
+	// knownHost describes how to find the repository of the import paths for a host
+	knownHost struct {
+		// pattern matches the repository root of an import path
+		pattern *regexp.Regexp
+		vcs     string
+		// url builds the repository url from the repository root
+		url func(root string) string
+	}
+)
+
+var httpsURL = func(root string) string {
+	return "https://" + root
+}
+
+// replaceURL returns a function which builds the repository url by replacing
+// the prefix of the repository root
+func replaceURL(prefix, replacement string) func(root string) string {
+	return func(root string) string {
+		return replacement + strings.TrimPrefix(root, prefix)
+	}
+}
+
+// codeHosts are the hosts which serve repositories directly, so their
+// repository roots are known without making any request
+var codeHosts = []knownHost{
+	{
+		pattern: regexp.MustCompile(`^github\.com/[A-Za-z0-9_.\-]+/[A-Za-z0-9_.\-]+`),
+		vcs:     "git",
+		url:     httpsURL,
+	},
+	{
+		pattern: regexp.MustCompile(`^bitbucket\.org/[A-Za-z0-9_.\-]+/[A-Za-z0-9_.\-]+`),
+		vcs:     "git",
+		url:     httpsURL,
+	},
+	{
+		pattern: regexp.MustCompile(`^gitlab\.com/[A-Za-z0-9_.\-]+/[A-Za-z0-9_.\-]+`),
+		vcs:     "git",
+		url:     httpsURL,
+	},
+	{
+		pattern: regexp.MustCompile(`^launchpad\.net/(?:[A-Za-z0-9_.\-]+(?:/[A-Za-z0-9_.\-]+)?|~[A-Za-z0-9_.\-]+/(?:\+junk|[A-Za-z0-9_.\-]+)/[A-Za-z0-9_.\-]+)`),
+		vcs:     "bzr",
+		url:     httpsURL,
+	},
+	{
+		pattern: regexp.MustCompile(`^hub\.jazz\.net/git/[a-z0-9]+/[A-Za-z0-9_.\-]+`),
+		vcs:     "git",
+		url:     httpsURL,
+	},
+	{
+		pattern: regexp.MustCompile(`^git\.apache\.org/[a-z0-9_.\-]+\.git`),
+		vcs:     "git",
+		url:     httpsURL,
+	},
+	{
+		pattern: regexp.MustCompile(`^git\.openstack\.org/[A-Za-z0-9_.\-]+/[A-Za-z0-9_.\-]+`),
+		vcs:     "git",
+		url:     httpsURL,
+	},
+}
+
+// vanityHosts are the well known hosts which redirect to repositories using
+// go-import meta tags. They are used when the meta tags can't be fetched.
+var vanityHosts = []knownHost{
+	{
+		pattern: regexp.MustCompile(`^gopkg\.in/(?:[a-zA-Z0-9][-a-zA-Z0-9]*/)?[a-zA-Z][-.a-zA-Z0-9]*\.v[0-9]+(?:-unstable)?`),
+		vcs:     "git",
+		url:     httpsURL,
+	},
+	{
+		pattern: regexp.MustCompile(`^golang\.org/x/[A-Za-z0-9_.\-]+`),
+		vcs:     "git",
+		url:     replaceURL("golang.org/x/", "https://go.googlesource.com/"),
+	},
+	{
+		pattern: regexp.MustCompile(`^k8s\.io/[A-Za-z0-9_.\-]+`),
+		vcs:     "git",
+		url:     replaceURL("k8s.io/", "https://github.com/kubernetes/"),
+	},
+	{
+		pattern: regexp.MustCompile(`^google\.golang\.org/grpc`),
+		vcs:     "git",
+		url:     replaceURL("google.golang.org/grpc", "https://github.com/grpc/grpc-go"),
+	},
+	{
+		pattern: regexp.MustCompile(`^google\.golang\.org/appengine`),
+		vcs:     "git",
+		url:     replaceURL("google.golang.org/appengine", "https://github.com/golang/appengine"),
+	},
+	{
+		pattern: regexp.MustCompile(`^google\.golang\.org/genproto`),
+		vcs:     "git",
+		url:     replaceURL("google.golang.org/genproto", "https://github.com/google/go-genproto"),
+	},
+	{
+		pattern: regexp.MustCompile(`^google\.golang\.org/api`),
+		vcs:     "git",
+		url:     replaceURL("google.golang.org/api", "https://code.googlesource.com/google-api-go-client"),
+	},
+	{
+		pattern: regexp.MustCompile(`^cloud\.google\.com/go`),
+		vcs:     "git",
+		url:     replaceURL("cloud.google.com/go", "https://code.googlesource.com/gocloud"),
+	},
+}
+
+// newHTTPClient returns the client used to fetch the go-import meta tags
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+	}
+}
+
+// matchKnownHost returns the repository of the import path from the known hosts
+func matchKnownHost(hosts []knownHost, importPath string) (repoRoot, bool) {
+	for _, host := range hosts {
+		root := host.pattern.FindString(importPath)
+		if root == "" {
+			continue
+		}
+		if root != importPath && !strings.HasPrefix(importPath, root+"/") {
+			continue
+		}
+
+		return repoRoot{
+			root: root,
+			vcs:  host.vcs,
+			url:  host.url(root),
+		}, true
+	}
+
+	return repoRoot{}, false
+}
+
+// discoverRepoRoot finds the repository of the import path. The known code
+// hosts are checked first, then the go-import meta tags served at
+// https://<import path>?go-get=1 are used and, should that fail, the well
+// known vanity hosts are checked. In offline mode, the repositories of the
+// cache are used instead of the go-import meta tags.
+func (d *Deep) discoverRepoRoot(importPath string) (repoRoot, error) {
+	if root, ok := matchKnownHost(codeHosts, importPath); ok {
+		return root, nil
+	}
+
+	if d.offline {
+		if root, ok := d.rootFromCache(importPath); ok {
+			return root, nil
+		}
+		if root, ok := matchKnownHost(vanityHosts, importPath); ok {
+			return root, nil
+		}
+		return repoRoot{}, &Error{Kind: ErrOffline, Package: importPath, Err: errors.New("the repository is not in the cache")}
+	}
+
+	root, err := d.fetchRepoRoot(importPath)
+	if err == nil {
+		return root, nil
+	}
+
+	if root, ok := matchKnownHost(vanityHosts, importPath); ok {
+		return root, nil
+	}
+
+	return repoRoot{}, fmt.Errorf("could not find the repository of %s: %v", importPath, err)
+}
+
+// fetchRepoRoot finds the repository of the import path using go-import meta tags.
+// When the import path is below the prefix of the matching tag, the page of the
+// prefix must serve the same tag, as go get requires.
+func (d *Deep) fetchRepoRoot(importPath string) (repoRoot, error) {
+	match, err := d.fetchMetaImport(importPath)
+	if err != nil {
+		return repoRoot{}, err
+	}
+
+	if match.prefix != importPath {
+		confirmed, err := d.fetchMetaImport(match.prefix)
+		if err != nil {
+			return repoRoot{}, fmt.Errorf("could not confirm the repository of %s: %v", importPath, err)
+		}
+		if confirmed != match {
+			return repoRoot{}, fmt.Errorf("the go-import meta tag of %s does not match the one of %s", match.prefix, importPath)
+		}
+	}
+
+	if _, ok := vcsByName(match.vcs); !ok {
+		return repoRoot{}, fmt.Errorf("unknown version control system %s for %s", match.vcs, importPath)
+	}
+
+	return repoRoot{
+		root: match.prefix,
+		vcs:  match.vcs,
+		url:  match.repoRoot,
+	}, nil
+}
+
+// fetchMetaImport returns the go-import meta tag matching the import path from
+// the page served at <import path>?go-get=1
+func (d *Deep) fetchMetaImport(importPath string) (metaImport, error) {
+	url := d.discoveryScheme + "://" + importPath + "?go-get=1"
+	resp, err := d.httpClient.Get(url)
+	if err != nil {
+		return metaImport{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return metaImport{}, fmt.Errorf("unexpected status %s for %s", resp.Status, url)
+	}
+
+	imports, err := parseMetaImports(resp.Body)
+	if err != nil {
+		return metaImport{}, err
+	}
+
+	var match *metaImport
+	for idx, imp := range imports {
+		if imp.vcs == "mod" {
+			continue
+		}
+		if imp.prefix != importPath && !strings.HasPrefix(importPath, imp.prefix+"/") {
+			continue
+		}
+		if match != nil {
+			return metaImport{}, fmt.Errorf("multiple go-import meta tags match %s at %s", importPath, url)
+		}
+		match = &imports[idx]
+	}
+
+	if match == nil {
+		return metaImport{}, fmt.Errorf("no go-import meta tag matches %s at %s", importPath, url)
+	}
+	return *match, nil
+}
+
+// parseMetaImports returns the go-import meta tags from the head of the html document
+func parseMetaImports(r io.Reader) ([]metaImport, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "ascii", "utf-8", "utf8":
+			return input, nil
+		}
+		return nil, fmt.Errorf("can't decode the %s charset", charset)
+	}
+
+	var imports []metaImport
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			return imports, nil
+		}
+		if err != nil {
+			if len(imports) > 0 {
+				return imports, nil
+			}
+			return nil, err
+		}
+
+		if end, ok := token.(xml.EndElement); ok && strings.EqualFold(end.Name.Local, "head") {
+			return imports, nil
+		}
+		if start, ok := token.(xml.StartElement); ok && strings.EqualFold(start.Name.Local, "body") {
+			return imports, nil
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || !strings.EqualFold(start.Name.Local, "meta") {
+			continue
+		}
+		if attrValue(start.Attr, "name") != "go-import" {
+			continue
+		}
+
+		fields := strings.Fields(attrValue(start.Attr, "content"))
+		if len(fields) != 3 {
+			continue
+		}
+		imports = append(imports, metaImport{
+			prefix:   fields[0],
+			vcs:      fields[1],
+			repoRoot: fields[2],
+		})
+	}
+}
+
+func attrValue(attrs []xml.Attr, name string) string {
+	for _, attr := range attrs {
+		if strings.EqualFold(attr.Name.Local, name) {
+			return attr.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,163 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// discoveryPages are the pages served by the stand-in server, by host and path
+var discoveryPages = map[string]string{
+	"example.com/foo/bar/baz": `<html><head>
+<meta name="go-import" content="example.com/other git https://git.example.com/other">
+<meta name="go-import" content="example.com/foo/bar git https://git.example.com/foo/bar">
+</head></html>`,
+	"example.com/foo/bar": `<html><head>
+<meta name="go-import" content="example.com/foo/bar git https://git.example.com/foo/bar">
+</head></html>`,
+	// The page of the prefix disagrees with the one of the package
+	"example.com/disagree/pkg": `<html><head>
+<meta name="go-import" content="example.com/disagree git https://git.example.com/disagree">
+</head></html>`,
+	"example.com/disagree": `<html><head>
+<meta name="go-import" content="example.com/disagree git https://git.example.com/elsewhere">
+</head></html>`,
+	// The page of the prefix is missing
+	"example.com/unconfirmed/pkg": `<html><head>
+<meta name="go-import" content="example.com/unconfirmed git https://git.example.com/unconfirmed">
+</head></html>`,
+	"example.com/multiple": `<html><head>
+<meta name="go-import" content="example.com/multiple git https://git.example.com/multiple">
+<meta name="go-import" content="example.com/multiple hg https://hg.example.com/multiple">
+</head></html>`,
+	"example.com/module": `<html><head>
+<meta name="go-import" content="example.com/module mod https://proxy.example.com">
+<meta name="go-import" content="example.com/module git https://git.example.com/module">
+</head></html>`,
+	"example.com/unknown": `<html><head>
+<meta name="go-import" content="example.com/unknown fossil https://fossil.example.com/unknown">
+</head></html>`,
+	"example.com/body": `<html><head></head><body>
+<meta name="go-import" content="example.com/body git https://git.example.com/body">
+</body></html>`,
+	"example.com/unquoted": `<!DOCTYPE html>
+<html><head><meta charset=utf-8>
+<meta name=go-import content="example.com/unquoted git https://git.example.com/unquoted">`,
+}
+
+func TestDiscoverRepoRoot(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("go-get") != "1" {
+			http.Error(w, "missing go-get=1", http.StatusBadRequest)
+			return
+		}
+		page, ok := discoveryPages[r.Host+r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, page)
+	}))
+	defer server.Close()
+
+	d := New(func(string, ...interface{}) {})
+	d.discoveryScheme = "http"
+	// Every host is served by the stand-in server
+	d.httpClient = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+			},
+		},
+	}
+
+	tests := []struct {
+		importPath string
+		want       repoRoot
+		err        string
+	}{
+		{
+			importPath: "github.com/dlsniper/deep/cmd/deep",
+			want:       repoRoot{root: "github.com/dlsniper/deep", vcs: "git", url: "https://github.com/dlsniper/deep"},
+		},
+		{
+			importPath: "example.com/foo/bar/baz",
+			want:       repoRoot{root: "example.com/foo/bar", vcs: "git", url: "https://git.example.com/foo/bar"},
+		},
+		{
+			importPath: "example.com/disagree/pkg",
+			err:        "the go-import meta tag of example.com/disagree does not match",
+		},
+		{
+			importPath: "example.com/unconfirmed/pkg",
+			err:        "could not confirm the repository of example.com/unconfirmed/pkg",
+		},
+		{
+			importPath: "example.com/multiple",
+			err:        "multiple go-import meta tags match",
+		},
+		{
+			importPath: "example.com/module",
+			want:       repoRoot{root: "example.com/module", vcs: "git", url: "https://git.example.com/module"},
+		},
+		{
+			importPath: "example.com/unknown",
+			err:        "unknown version control system fossil",
+		},
+		{
+			importPath: "example.com/body",
+			err:        "no go-import meta tag matches",
+		},
+		{
+			importPath: "example.com/unquoted",
+			want:       repoRoot{root: "example.com/unquoted", vcs: "git", url: "https://git.example.com/unquoted"},
+		},
+		{
+			importPath: "example.com/missing",
+			err:        "unexpected status 404",
+		},
+		{
+			importPath: "gopkg.in/yaml.v2",
+			want:       repoRoot{root: "gopkg.in/yaml.v2", vcs: "git", url: "https://gopkg.in/yaml.v2"},
+		},
+		{
+			importPath: "golang.org/x/net/context",
+			want:       repoRoot{root: "golang.org/x/net", vcs: "git", url: "https://go.googlesource.com/net"},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := d.discoverRepoRoot(tt.importPath)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.importPath, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.importPath, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.importPath, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"errors"
+)
+
+var (
+	// ErrPackageNotFound is the kind of error returned when a package, its
+	// repository or a version matching the requested one can't be found
+	ErrPackageNotFound = errors.New("package not found")
+
+	// ErrCheckoutFailed is the kind of error returned when the repository of a
+	// package can't be cloned or the requested revision can't be checked out
+	ErrCheckoutFailed = errors.New("checkout failed")
+
+	// ErrConflict is the kind of error returned when the versions requested for
+	// a package can't be satisfied together, see ConflictError for the details
+	ErrConflict = errors.New("version conflict")
+
+	// ErrManifestInvalid is the kind of error returned when a manifest or a lock
+	// file can't be parsed
+	ErrManifestInvalid = errors.New("invalid manifest")
+
+	// ErrDigestMismatch is the kind of error returned when a package kept from a
+	// previous run doesn't match the digest of the lock anymore
+	ErrDigestMismatch = errors.New("vendored content does not match the lock")
+
+	// ErrOffline is the kind of error returned in offline mode when a package
+	// can't be vendored without accessing the network
+	ErrOffline = errors.New("network access needed in offline mode")
+)
+
+// Error is the error returned by Deep for a package. Kind is one of the Err
+// variables of this package and Err is the underlying cause.
+type Error struct {
+	Kind    error
+	Package string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	msg := e.Kind.Error()
+	if e.Package != "" {
+		msg += " for " + e.Package
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying cause of the error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports if the error is of the given kind
+func (e *Error) Is(kind error) bool {
+	return e.Kind == kind
+}
+
+// IsKind reports if the error returned by Deep is of the given kind
+func IsKind(err error, kind error) bool {
+	switch err := err.(type) {
+	case *Error:
+		return err.Is(kind)
+	case *ConflictError:
+		return err.Is(kind)
+	}
+	return false
+}
+
+// newError creates an error of the given kind for the package, unless the cause
+// already is one, in which case only its package is filled in when missing
+func newError(kind error, pkg string, err error) error {
+	if e, ok := err.(*Error); ok {
+		if e.Package == "" {
+			e.Package = pkg
+		}
+		return e
+	}
+	return &Error{Kind: kind, Package: pkg, Err: err}
+}
@@ -0,0 +1,190 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"sync"
+)
+
+// defaultJobs is the number of packages fetched at the same time by default
+var defaultJobs = runtime.NumCPU()
+
+// fetch is a package to vendor along with the output of vendoring it
+type fetch struct {
+	pkg      *Package
+	versions []string
+	output   bytes.Buffer
+	err      error
+	done     chan struct{}
+}
+
+// SetJobs sets the maximum number of packages fetched at the same time.
+// Values lower than 1 restore the default, the number of CPUs.
+func (d *Deep) SetJobs(jobs int) {
+	if jobs < 1 {
+		jobs = defaultJobs
+	}
+	d.jobs = jobs
+}
+
+// vendorResolution vendors the packages of the resolution level by level. The
+// packages of a level are fetched concurrently, then the packages they vendor
+// are moved to the vendor directory of the project and the dependencies they
+// need are added to the resolution as the next level. The packages for which
+// replace returns true are replaced when already vendored. Once everything is
+// vendored, the kept packages which had directories pruned that are imported
+// again are vendored again as the last level.
+func (d *Deep) vendorResolution(ctx context.Context, pwd, currentPkg string, r *resolution, replace func(idx int) bool) error {
+	pruned := map[int]bool{}
+	replaceOrPruned := func(idx int) bool {
+		return pruned[idx] || replace(idx)
+	}
+
+	batch := packageRange(0, len(r.packages))
+	for len(batch) > 0 {
+		end := len(r.packages)
+		err := d.fetchPackages(ctx, pwd, r, batch, replaceOrPruned)
+		if err != nil {
+			return err
+		}
+
+		for _, idx := range batch {
+			d.hoistVendor(pwd, r, idx)
+
+			err = d.addRequestedDependencies(ctx, pwd, r, idx)
+			if err != nil {
+				return err
+			}
+
+			err = d.requireImports(pwd, currentPkg, r, idx)
+			if err != nil {
+				return err
+			}
+		}
+
+		batch = packageRange(end, len(r.packages))
+		if len(batch) > 0 {
+			continue
+		}
+
+		batch, err = d.prunedImports(pwd, r)
+		if err != nil {
+			return err
+		}
+		for _, idx := range batch {
+			pruned[idx] = true
+		}
+	}
+
+	return nil
+}
+
+// packageRange returns the indexes of the packages from start to end
+func packageRange(start, end int) []int {
+	var result []int
+	for idx := start; idx < end; idx++ {
+		result = append(result, idx)
+	}
+	return result
+}
+
+// fetchPackages vendors the packages of the resolution found at the indexes of
+// the batch, with at most d.jobs of them fetched at the same time. The output
+// of each package is buffered and logged in order. The first failure cancels
+// the fetches still running and is returned.
+func (d *Deep) fetchPackages(ctx context.Context, pwd string, r *resolution, batch []int, replace func(idx int) bool) error {
+	// The user may be asked about the vendored packages so this is done in order
+	var fetches []*fetch
+	for _, idx := range batch {
+		// The packages moved from a nested vendor directory are already there
+		if r.packages[idx].fetched {
+			continue
+		}
+
+		ok, err := d.needsFetch(pwd, r.packages[idx], r.lock, replace(idx))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		r.packages[idx].fetched = true
+		fetches = append(fetches, &fetch{
+			pkg:      &r.packages[idx],
+			versions: r.versions(r.packages[idx].Name),
+			done:     make(chan struct{}),
+		})
+	}
+
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		once    sync.Once
+		failure error
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			failure = err
+			cancel()
+		})
+	}
+
+	// The fetches are started in order, as soon as a job is free
+	jobs := make(chan struct{}, d.jobs)
+	go func() {
+		for _, f := range fetches {
+			select {
+			case jobs <- struct{}{}:
+			case <-fetchCtx.Done():
+				f.err = fetchCtx.Err()
+				close(f.done)
+				continue
+			}
+
+			go func(f *fetch) {
+				defer close(f.done)
+				defer func() { <-jobs }()
+
+				f.err = d.vendorRepository(fetchCtx, pwd, f.pkg, f.versions, &f.output)
+				if f.err == nil {
+					return
+				}
+				// The fetches killed by the cancellation are not failures
+				if err := fetchCtx.Err(); err != nil {
+					f.err = err
+					return
+				}
+				fail(f.err)
+			}(f)
+		}
+	}()
+
+	for _, f := range fetches {
+		<-f.done
+		if f.output.Len() > 0 {
+			d.log("%s", d.displayOutput(f.output.String()))
+		}
+	}
+
+	if failure != nil {
+		return failure
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,261 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hoistVendor moves the packages vendored by the package into the vendor
+// directory of the project and adds them to the resolution, before the imports
+// of the package are resolved, so that they are not fetched again. The packages
+// already selected or overridden are left in place, flattenVendor compares them
+// with the copy of the project once everything is vendored.
+func (d *Deep) hoistVendor(pwd string, r *resolution, idx int) {
+	pkg := r.packages[idx]
+	nestedPath := d.vendoredPath(pwd, pkg) + pathSeparatorString + "vendor"
+	pathExists, err := d.pathExists(nestedPath)
+	if err != nil || !pathExists {
+		return
+	}
+
+	roots, err := d.nestedRoots(pwd, nestedPath)
+	if err != nil {
+		d.log("Error while reading the nested vendor directory of %s: %v\n", pkg.Name, err)
+		return
+	}
+
+	// The lock of the package tells which revisions it vendored, if it has one
+	locked, _ := lockedDependencies(d.vendoredPath(pwd, pkg))
+	lockedPkg := Package{Dependencies: locked}
+
+	for _, root := range roots {
+		if _, ok := r.overrides[root]; ok {
+			continue
+		}
+
+		nested := Package{
+			Name:    root,
+			Version: defaultVersion,
+		}
+		if dep, ok := lockedPkg.dependency(root); ok {
+			nested.Version, nested.Tag, nested.CommitHash = dep.Version, dep.Tag, dep.CommitHash
+		}
+		if !r.require(pkg.Name, nested) {
+			continue
+		}
+
+		// When the package can't be moved, it's fetched like any other one
+		src := filepath.Join(nestedPath, filepath.FromSlash(root))
+		dst := d.vendoredPath(pwd, nested)
+		if err := d.moveNested(src, dst); err != nil {
+			d.log("Error while moving %s from the vendor directory of %s: %v\n", root, pkg.Name, err)
+			continue
+		}
+
+		hoisted := &r.packages[len(r.packages)-1]
+		hoisted.Tag, hoisted.CommitHash = nested.Tag, nested.CommitHash
+		hoisted.fetched = true
+		d.addKnownRoot(repoRoot{root: root})
+		d.log("Moved %s from the vendor directory of %s\n", root, pkg.Name)
+	}
+}
+
+// moveNested moves the nested package to the vendor directory of the project,
+// replacing the copy left there by a previous run
+func (d *Deep) moveNested(src, dst string) error {
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
+
+// flattenVendor removes the nested vendor directories of the packages. Their
+// packages were either moved to the vendor directory of the project or are
+// vendored by it already, in which case a conflict is reported when the nested
+// copy differs from the one of the project, which is kept.
+func (d *Deep) flattenVendor(pwd string, packages []Package) {
+	for _, pkg := range packages {
+		nestedPath := d.vendoredPath(pwd, pkg) + pathSeparatorString + "vendor"
+		pathExists, err := d.pathExists(nestedPath)
+		if err != nil || !pathExists {
+			continue
+		}
+
+		roots, err := d.nestedRoots(pwd, nestedPath)
+		if err != nil {
+			d.log("Error while reading the nested vendor directory of %s: %v\n", pkg.Name, err)
+		}
+
+		locked, _ := lockedDependencies(d.vendoredPath(pwd, pkg))
+		lockedPkg := Package{Dependencies: locked}
+
+		for _, root := range roots {
+			vendored, ok := d.topLevelPackage(pwd, packages, root)
+			if !ok {
+				continue
+			}
+
+			nestedRoot := filepath.Join(nestedPath, filepath.FromSlash(root))
+			revision := ""
+			if v, ok := vcsForDir(nestedRoot); ok {
+				revision, _ = v.revision(nestedRoot)
+			} else if dep, ok := lockedPkg.dependency(root); ok {
+				revision = dep.CommitHash
+			}
+
+			switch {
+			case revision != "" && vendored.CommitHash != "":
+				if revision != vendored.CommitHash {
+					d.log("Conflict for %s: %s vendors %s but %s is vendored by the project, keeping the latter\n",
+						root, pkg.Name, revision, vendored.CommitHash)
+				}
+			case !d.sameSources(nestedRoot, d.vendoredPath(pwd, vendored)):
+				d.log("Conflict for %s: %s vendors another copy than the one vendored by the project, keeping the latter\n",
+					root, pkg.Name)
+			}
+		}
+
+		if err := os.RemoveAll(nestedPath); err != nil {
+			d.log("Error while wiping nested vendor folders %v\n", err)
+		}
+	}
+}
+
+// sameSources reports if the Go files of the nested copy of a package, test
+// files excepted, are found unchanged in the copy of the project. Only the Go
+// files are compared since either copy may have been stripped.
+func (d *Deep) sameSources(nestedRoot, vendoredRoot string) bool {
+	same := true
+	err := filepath.Walk(nestedRoot, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() {
+			if path != nestedRoot && (f.Name() == "vendor" || d.isVCSDir(f.Name())) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(f.Name(), ".go") || strings.HasSuffix(f.Name(), "_test.go") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(nestedRoot, path)
+		if err != nil {
+			return err
+		}
+		vendoredPath := filepath.Join(vendoredRoot, rel)
+		vendoredFile, err := os.Lstat(vendoredPath)
+		if err != nil {
+			same = false
+			return filepath.SkipDir
+		}
+
+		nestedHash, err := fileHash(path, f)
+		if err != nil {
+			return err
+		}
+		vendoredHash, err := fileHash(vendoredPath, vendoredFile)
+		if err != nil {
+			return err
+		}
+		if nestedHash != vendoredHash {
+			same = false
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	return err == nil && same
+}
+
+// topLevelPackage returns the package vendored by the project with the given
+// name, either during this run or before it
+func (d *Deep) topLevelPackage(pwd string, packages []Package, name string) (Package, bool) {
+	for _, pkg := range packages {
+		if pkg.Name == name {
+			return pkg, true
+		}
+	}
+
+	pkg := Package{Name: name}
+	pathExists, err := d.pathExists(d.vendoredPath(pwd, pkg))
+	if err != nil || !pathExists {
+		return pkg, false
+	}
+
+	if lock, err := readLock(pwd); err == nil {
+		if locked, ok := lock.dependency(name); ok {
+			return locked, true
+		}
+	}
+	return pkg, true
+}
+
+// nestedRoots returns the repository roots of the packages found in the nested
+// vendor directory. When the root of a package can't be found, the shallowest
+// directory holding Go files is used instead.
+func (d *Deep) nestedRoots(pwd, nestedPath string) ([]string, error) {
+	var roots []string
+	err := filepath.Walk(nestedPath, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !f.IsDir() || path == nestedPath {
+			return nil
+		}
+		if d.isVCSDir(f.Name()) {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(nestedPath, path)
+		if err != nil {
+			return err
+		}
+		importPath := filepath.ToSlash(rel)
+
+		// The rest of a repository is moved along with it
+		for _, root := range roots {
+			if strings.HasPrefix(importPath, root+"/") {
+				return filepath.SkipDir
+			}
+		}
+
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		if !hasGoFiles(files) {
+			return nil
+		}
+
+		root := importPath
+		if repo, err := d.repoRoot(pwd, importPath); err == nil && strings.HasPrefix(importPath+"/", repo.root+"/") {
+			root = repo.root
+		}
+		roots = append(roots, root)
+
+		return nil
+	})
+
+	return roots, err
+}
@@ -0,0 +1,222 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"go/build"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// skipImportsDir reports if a directory should not be scanned for imports
+func skipImportsDir(name string) bool {
+	return name == "vendor" ||
+		name == "testdata" ||
+		strings.HasPrefix(name, ".") ||
+		strings.HasPrefix(name, "_")
+}
+
+// walkPackages calls fn for every Go package found in the directory and its
+// subdirectories, once for each build context. Nested vendor, testdata and the
+// directories ignored by the go tool are skipped, as well as the directories for
+// which skip returns true.
+func walkPackages(dir string, contexts []*build.Context, skip func(path string) bool, fn func(pkg *build.Package)) error {
+	return filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !f.IsDir() {
+			return nil
+		}
+		if path != dir && (skipImportsDir(f.Name()) || skip != nil && skip(path)) {
+			return filepath.SkipDir
+		}
+
+		for _, ctx := range contexts {
+			pkg, err := ctx.ImportDir(path, build.ImportComment)
+			if err != nil {
+				if _, ok := err.(*build.NoGoError); ok {
+					continue
+				}
+			}
+
+			fn(pkg)
+		}
+		return nil
+	})
+}
+
+// importsOf returns the sorted list of packages imported by the Go files found
+// in the directory and its subdirectories for any of the build contexts. The
+// imports of the test files are included when tests is true.
+func importsOf(dir string, contexts []*build.Context, tests bool) ([]string, error) {
+	seen := map[string]struct{}{}
+	err := walkPackages(dir, contexts, nil, func(pkg *build.Package) {
+		for _, imp := range packageImports(pkg, tests) {
+			if imp == "C" || build.IsLocalImport(imp) {
+				continue
+			}
+			seen[imp] = struct{}{}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	imports := make([]string, 0, len(seen))
+	for imp := range seen {
+		imports = append(imports, imp)
+	}
+	sort.Strings(imports)
+
+	return imports, nil
+}
+
+// packageImports returns the imports of the package, with the imports of its
+// test files when tests is true
+func packageImports(pkg *build.Package, tests bool) []string {
+	imports := pkg.Imports
+	if tests {
+		imports = append(append(imports[:len(imports):len(imports)], pkg.TestImports...), pkg.XTestImports...)
+	}
+	return imports
+}
+
+// importingFiles returns the sorted positions in the Go files, tests included,
+// that import the package or any of its subpackages for any of the build contexts
+func importingFiles(dir string, contexts []*build.Context, skip func(path string) bool, pkgName string) ([]string, error) {
+	var result []string
+	seen := map[string]struct{}{}
+	err := walkPackages(dir, contexts, skip, func(pkg *build.Package) {
+		for _, importPos := range []map[string][]token.Position{pkg.ImportPos, pkg.TestImportPos, pkg.XTestImportPos} {
+			for imp, positions := range importPos {
+				if imp != pkgName && !strings.HasPrefix(imp, pkgName+"/") {
+					continue
+				}
+				for _, pos := range positions {
+					if _, ok := seen[pos.String()]; ok {
+						continue
+					}
+					seen[pos.String()] = struct{}{}
+					result = append(result, pos.String())
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(result)
+	return result, nil
+}
+
+// thirdPartyImports returns the packages of the repositories holding the third-party
+// packages imported by the package, excluding the ones contained by the package itself.
+// When tests is true, the packages imported only by the test files are included and
+// marked as test only.
+func (d *Deep) thirdPartyImports(pwd, currentPkg string, contexts []*build.Context, pkg Package, tests bool) ([]Package, error) {
+	imports, err := importsOf(d.vendoredPath(pwd, pkg), contexts, false)
+	if err != nil {
+		return nil, err
+	}
+
+	testOnly := map[string]bool{}
+	if tests {
+		allImports, err := importsOf(d.vendoredPath(pwd, pkg), contexts, true)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, imp := range imports {
+			testOnly[imp] = false
+		}
+		for _, imp := range allImports {
+			if _, ok := testOnly[imp]; !ok {
+				testOnly[imp] = true
+			}
+		}
+		imports = allImports
+	}
+
+	var result []Package
+	seen := map[string]int{}
+	for _, imp := range imports {
+		p := Package{Name: imp, TestOnly: testOnly[imp]}
+		if !p.isThirdParty(currentPkg) {
+			continue
+		}
+		if imp == pkg.Name || strings.HasPrefix(imp, pkg.Name+"/") {
+			continue
+		}
+
+		p = d.rootPackage(pwd, p)
+		if idx, ok := seen[p.Name]; ok {
+			// The repository is needed by the package if any of its packages is
+			result[idx].TestOnly = result[idx].TestOnly && p.TestOnly
+			continue
+		}
+		seen[p.Name] = len(result)
+		result = append(result, p)
+	}
+
+	return result, nil
+}
+
+// requireImports adds the repositories imported by the vendored package which
+// are not yet part of the resolution, at the default version. The imports of the
+// test files are included for the packages which requested them. The imported
+// repositories missing from the manifest of the package are recorded as its
+// dependencies at the default version.
+func (d *Deep) requireImports(pwd, currentPkg string, r *resolution, idx int) error {
+	pkg := r.packages[idx]
+	deps, err := d.thirdPartyImports(pwd, currentPkg, r.contexts, pkg, d.includesTests(pkg))
+	if err != nil {
+		return err
+	}
+
+	for _, dep := range deps {
+		r.addImport(pkg.Name, dep.Name, dep.TestOnly)
+		if _, ok := r.packages[idx].dependency(dep.Name); !ok {
+			r.packages[idx].setDependency(Package{
+				Name:     dep.Name,
+				Version:  defaultVersion,
+				TestOnly: dep.TestOnly,
+			})
+		}
+		if _, _, ok := r.selected(dep.Name); ok {
+			continue
+		}
+
+		dep.Version = defaultVersion
+		r.require(pkg.Name, dep)
+	}
+
+	return nil
+}
+
+// includesTests reports if the test dependencies of the package are vendored
+func (d *Deep) includesTests(pkg Package) bool {
+	if pkg.Tests {
+		return true
+	}
+
+	_, all := d.testDeps[allTestDependencies]
+	_, ok := d.testDeps[pkg.Name]
+	return all || ok
+}
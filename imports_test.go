@@ -0,0 +1,87 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestImportedDependencies checks that the repositories found by scanning the
+// imports of a package without a manifest are recorded as its dependencies
+func TestImportedDependencies(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "deep-imports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lib := createTestRepo(t, dir, "lib", map[string]string{
+		"lib.go":      "package lib\n\nimport _ \"example.com/util\"\n",
+		"lib_test.go": "package lib\n\nimport _ \"example.com/testutil\"\n",
+	})
+	util := createTestRepo(t, dir, "util", map[string]string{
+		"util.go": "package util\n",
+	})
+	testutil := createTestRepo(t, dir, "testutil", map[string]string{
+		"testutil.go": "package testutil\n",
+	})
+
+	pwd := filepath.Join(dir, "src", "example.com", "app")
+	writeTestFiles(t, pwd, map[string]string{
+		manifestFileName: `{"dependencies": [
+	{"name": "example.com/lib", "version": "*", "source": "file://` + filepath.ToSlash(lib) + `", "tests": true},
+	{"name": "example.com/util", "version": "*", "source": "file://` + filepath.ToSlash(util) + `"},
+	{"name": "example.com/testutil", "version": "*", "source": "file://` + filepath.ToSlash(testutil) + `"}
+]}`,
+		"main.go": "package main\n\nimport _ \"example.com/lib\"\n\nfunc main() {}\n",
+	})
+
+	want := []Package{
+		{Name: "example.com/testutil", Version: defaultVersion, TestOnly: true},
+		{Name: "example.com/util", Version: defaultVersion},
+	}
+	// The second run keeps the vendored packages
+	for run := 1; run <= 2; run++ {
+		d := New(func(string, ...interface{}) {})
+		d.SetCacheDir(filepath.Join(dir, "cache"))
+		d.input = strings.NewReader("")
+		if err := d.Run(context.Background(), pwd, "example.com/app", map[string]struct{}{stripTests: {}}); err != nil {
+			t.Fatal(err)
+		}
+
+		lock, err := readLock(pwd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		locked, ok := lock.dependency("example.com/lib")
+		if !ok {
+			t.Fatalf("run %d: example.com/lib is not in the lock", run)
+		}
+		if !reflect.DeepEqual(locked.Dependencies, want) {
+			t.Errorf("run %d: dependencies: got %+v, want %+v", run, locked.Dependencies, want)
+		}
+	}
+}
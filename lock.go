@@ -16,6 +16,7 @@ package deep
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"time"
 )
@@ -38,3 +39,18 @@ func (l *Lock) writeFile(path string) error {
 
 	return ioutil.WriteFile(path+pathSeparatorString+lockFileName, lk, 0644)
 }
+
+func readLock(path string) (*Lock, error) {
+	lk, err := ioutil.ReadFile(path + pathSeparatorString + lockFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Lock{}
+	err = json.Unmarshal(lk, l)
+	if err != nil {
+		return nil, newError(ErrManifestInvalid, "", fmt.Errorf("%s: %v", lockFileName, err))
+	}
+
+	return l, nil
+}
@@ -15,10 +15,12 @@
 package deep
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 )
@@ -32,13 +34,49 @@ type (
 		log       Logger
 		providers []provider
 		vcsDirs   []string
+
+		// httpClient and discoveryScheme are used to fetch the go-import meta tags
+		httpClient      *http.Client
+		discoveryScheme string
+		// roots holds the known repository roots
+		roots map[string]repoRoot
+		// testDeps are the packages for which the test dependencies are vendored
+		testDeps map[string]struct{}
+		// wipePolicy decides if the vendored packages are replaced, input is
+		// where the answers come from when the user is asked about it
+		wipePolicy WipePolicy
+		input      io.Reader
+		// staging is the directory where the run is prepared, when one is in progress
+		staging string
+		// jobs is the maximum number of packages fetched at the same time
+		jobs int
+		// cache is the directory of the repositories shared between projects
+		cache string
+		// offline forbids any network access
+		offline bool
 	}
 )
 
+// allTestDependencies selects the test dependencies of all the packages
+const allTestDependencies = "all"
+
 const pathSeparatorString = string(os.PathSeparator)
 
-func (d *Deep) listPackages(pwd, currentPkg string) []Package {
+// listPackages collects the packages from all usable providers, grouped by the
+// repository which holds them. When more than one provider returns the same
+// repository, the first provider wins.
+func (d *Deep) listPackages(pwd, currentPkg string) ([]Package, error) {
+	if locked, err := lockedDependencies(pwd); err == nil {
+		d.addKnownPackages(locked)
+	}
+	// The lock holds the transitive packages as well, including the ones moved
+	// from nested vendor directories which can't be found anywhere else
+	if lock, err := readLock(pwd); err == nil {
+		d.addKnownPackages(lock.Dependencies)
+	}
+
 	var packages []Package
+	seen := map[string]struct{}{}
 	for _, provider := range d.providers {
 		if !provider.canUse(pwd, currentPkg) {
 			continue
@@ -46,49 +84,139 @@ func (d *Deep) listPackages(pwd, currentPkg string) []Package {
 
 		pkgs, err := provider.packages(pwd, currentPkg)
 		if err != nil {
-			d.log("Error while getting packages: %v", err)
-			os.Exit(1)
+			return nil, err
 		}
 
 		for _, pkg := range pkgs {
 			if !pkg.isThirdParty(currentPkg) {
 				continue
 			}
+			pkg = d.rootPackage(pwd, pkg)
+			if _, ok := seen[pkg.Name]; ok {
+				continue
+			}
+			seen[pkg.Name] = struct{}{}
 			packages = append(packages, pkg)
 		}
 
 	}
 
-	return packages
+	return packages, nil
 }
 
-func (d *Deep) vendorGithubPackage(pwd string, pkg Package) error {
-	// TODO use github releases API to fetch that version directly
+// repository returns the version control system and the url of the repository
+// for the package
+func (d *Deep) repository(ctx context.Context, pkg Package) (vcs, string, error) {
+	url := pkg.Source
+	if url == "" {
+		root, err := d.discoverRepoRoot(pkg.Name)
+		if IsKind(err, ErrOffline) {
+			return nil, "", d.offlineError(pkg, "the repository is not in the cache")
+		}
+		if err != nil {
+			return nil, "", newError(ErrPackageNotFound, pkg.Name, err)
+		}
+
+		url = root.url
+		if pkg.VCS == "" {
+			v, _ := vcsByName(root.vcs)
+			return v, url, nil
+		}
+	}
 
-	//gitPath := "git@" + strings.Replace(pkg.Name, "github.com/", "github.com:", 1)
-	gitPath := "https://" + pkg.Name + ".git"
+	if pkg.VCS != "" {
+		v, ok := vcsByName(pkg.VCS)
+		if !ok {
+			return nil, "", newError(ErrPackageNotFound, pkg.Name, fmt.Errorf("unknown version control system %s", pkg.VCS))
+		}
+		return v, url, nil
+	}
+
+	if d.offline {
+		if root, ok := d.rootFromCache(pkg.Name); ok && root.root == pkg.Name {
+			v, _ := vcsByName(root.vcs)
+			return v, url, nil
+		}
+		return nil, "", d.offlineError(pkg, "the repository is not in the cache")
+	}
 
-	cmd := exec.Command("git", "clone", "-v", gitPath, pkg.vendoredPath(pwd))
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
+	v, ok := vcsForURL(ctx, url)
+	if !ok {
+		return nil, "", newError(ErrPackageNotFound, pkg.Name, fmt.Errorf("could not detect the version control system at %s", url))
+	}
+	return v, url, nil
+}
+
+// vendorRepository clones the repository of the package then checks out the
+// revision which satisfies the requested versions. The progress is written to out.
+func (d *Deep) vendorRepository(ctx context.Context, pwd string, pkg *Package, versions []string, out io.Writer) error {
+	v, url, err := d.repository(ctx, *pkg)
 	if err != nil {
 		return err
 	}
 
-	cmd = exec.Command("git", "checkout", pkg.Version)
-	cmd.Dir = pkg.vendoredPath(pwd)
-	return cmd.Run()
+	vendoredPath := d.vendoredPath(pwd, *pkg)
+	err = d.cloneRepository(ctx, v, url, *pkg, vendoredPath, out)
+	if err != nil {
+		return newError(ErrCheckoutFailed, pkg.Name, err)
+	}
+	pkg.VCS = v.name()
+
+	log := func(msg string, v ...interface{}) {
+		fmt.Fprintf(out, msg, v...)
+	}
+	revision, err := resolveRevision(v, vendoredPath, pkg, versions, log)
+	if err != nil {
+		return err
+	}
+
+	err = v.checkout(ctx, vendoredPath, revision)
+	if err != nil {
+		return newError(ErrCheckoutFailed, pkg.Name, err)
+	}
+	return nil
 }
 
-func (d *Deep) tryGitVendor(pwd string, pkg Package) error {
-	if strings.HasPrefix(pkg.Name, "github.com") {
-		return d.vendorGithubPackage(pwd, pkg)
+// resolveRevision returns the revision to checkout for the cloned package.
+// A locked commit hash is used as is, version constraints are resolved to the
+// highest tag matching all the requested versions, given to the version control
+// system as a tag, anything else is considered a branch or commit.
+func resolveRevision(v vcs, path string, pkg *Package, versions []string, log Logger) (string, error) {
+	if pkg.CommitHash != "" {
+		return pkg.CommitHash, nil
+	}
+
+	pkg.Tag = ""
+	version := pkg.Version
+	if len(versions) > 0 {
+		version = versions[0]
+	}
+	if !isVersionConstraint(version) {
+		return version, nil
+	}
+
+	tags, err := v.tags(path)
+	if err != nil {
+		return "", newError(ErrCheckoutFailed, pkg.Name, err)
+	}
+
+	// When the versions can't be satisfied together, use the package version
+	// and let the conflict be reported once the whole graph is known
+	tag, ok := highestMatchingTag(tags, versions)
+	if !ok {
+		tag, ok = highestMatchingTag(tags, []string{version})
+	}
+	if ok {
+		pkg.Tag = tag
+		return v.tagRevision(tag), nil
 	}
 
-	d.log("Could not vendor Git dependency as it's not starting with github.com")
-	return errors.New("Not a github package")
+	if version == defaultVersion {
+		log("No released version found for %s, using HEAD\n", pkg.Name)
+		return "HEAD", nil
+	}
+
+	return "", newError(ErrPackageNotFound, pkg.Name, fmt.Errorf("no version matches %s", version))
 }
 
 func (d *Deep) pathExists(path string) (bool, error) {
@@ -102,87 +230,85 @@ func (d *Deep) pathExists(path string) (bool, error) {
 	return false, err
 }
 
-func (d *Deep) shouldWipePath(path string) bool {
-	retries := 0
-	response := ""
-prompt:
-	retries++
-	fmt.Printf("Do you want to wipe %s [Y/n] ", path)
-	_, err := fmt.Scanln(&response)
+// vendorPackages vendors the packages then the dependencies they request in their
+// own manifest files, until the whole dependency graph is vendored
+func (d *Deep) vendorPackages(ctx context.Context, pwd, currentPkg string, packages []Package) ([]Package, error) {
+	manifest, err := readManifest(pwd)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	lock, err := readLock(pwd)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	r := newResolution(currentPkg, manifest, lock)
+	for _, pkg := range packages {
+		r.require(currentPkg, pkg)
+	}
+
+	err = d.vendorResolution(ctx, pwd, currentPkg, r, func(idx int) bool { return false })
 	if err != nil {
-		return false
+		return nil, err
 	}
 
-	response = strings.ToLower(response)
-	if response == "y" {
-		return true
-	} else if response == "n" {
-		return false
-	} else if retries > 3 {
-		return false
+	r.markTestOnly()
+
+	if conflicts := d.conflicts(pwd, r); len(conflicts) > 0 {
+		return nil, &ConflictError{Conflicts: conflicts}
 	}
 
-	goto prompt
+	for _, warning := range d.unneededOverrides(pwd, r) {
+		d.log("%s\n", warning)
+	}
+
+	return r.packages, nil
 }
 
-func (d *Deep) vendorPackages(pwd, currentPkg string, packages []Package) {
-	for _, pkg := range packages {
-		vendoredPath := pkg.vendoredPath(pwd)
-		pathExists, err := d.pathExists(vendoredPath)
-		if err != nil {
-			d.log("Got error while checking path %s %v Skipping\n", vendoredPath, err)
-			continue
-		}
+// needsFetch reports if the package has to be fetched. An already vendored
+// package is wiped when it is replaced, which happens when requested, otherwise
+// the wipe policy decides.
+func (d *Deep) needsFetch(pwd string, pkg Package, lock *Lock, replace bool) (bool, error) {
+	vendoredPath := d.vendoredPath(pwd, pkg)
+	pathExists, err := d.pathExists(vendoredPath)
+	if err != nil {
+		return false, err
+	}
 
-		if pathExists {
-			if !d.shouldWipePath(vendoredPath) {
-				d.log("Skipping existing path: %s\n", vendoredPath)
-				continue
-			}
-			err := os.RemoveAll(vendoredPath)
-			if err != nil {
-				d.log("Could not wipe existing path: %s %v\n", vendoredPath, err)
-				os.Exit(1)
-			}
+	if pathExists {
+		if !replace && !d.shouldReplace(pwd, pkg, lock) {
+			d.log("Skipping existing path: %s\n", d.displayPath(vendoredPath))
+			return false, nil
 		}
-
-		err = d.tryGitVendor(pwd, pkg)
+		err := os.RemoveAll(vendoredPath)
 		if err != nil {
-			d.log("Got error while trying to clone repository: %s %v\n", pkg.Name, err)
-			os.Exit(1)
+			return false, fmt.Errorf("could not wipe existing path %s: %v", d.displayPath(vendoredPath), err)
 		}
-		continue
 	}
+
+	return true, nil
 }
 
+// commitHash reads the commit hash of the checked out package. When this is
+// not possible, the already known commit hash or the version are used.
 func (d *Deep) commitHash(pwd, currentPkg string, pkg Package) string {
-	vendoredPath := pkg.vendoredPath(pwd)
-	for _, vcsDir := range d.vcsDirs {
-		pathExists, err := d.pathExists(vendoredPath + pathSeparatorString + vcsDir)
-		if err != nil {
-			d.log("Got error while checking path %s %v Skipping\n", pathExists, err)
-			continue
-		}
-
-		if !pathExists {
-			continue
+	vendoredPath := d.vendoredPath(pwd, pkg)
+	v, ok := vcsForDir(vendoredPath)
+	if !ok {
+		// A package moved from a nested vendor directory may have no known commit
+		if isDefaultVersion(pkg.Version) {
+			return pkg.CommitHash
 		}
+		return pkg.revision()
+	}
 
-		switch vcsDir {
-		case ".git":
-			cmd := exec.Command("git", "rev-parse", pkg.Version)
-			cmd.Dir = pkg.vendoredPath(pwd)
-			output, err := cmd.Output()
-			if err != nil {
-				d.log("Error while reading package %s version %v\n", pkg.Name, err)
-				return pkg.Version
-			}
-
-			return strings.TrimRight(string(output), "\n")
-		}
+	hash, err := v.revision(vendoredPath)
+	if err != nil {
+		d.log("Error while reading package %s version %v\n", pkg.Name, err)
+		return pkg.revision()
 	}
 
-	return pkg.Version
+	return hash
 }
 
 func (d *Deep) readCommitHashes(pwd, currentPkg string, packages []Package) {
@@ -191,19 +317,9 @@ func (d *Deep) readCommitHashes(pwd, currentPkg string, packages []Package) {
 	}
 }
 
-func (d *Deep) wipeNestedVendor(pwd string, currentPkg string, packages []Package) {
-	for _, pkg := range packages {
-		path := pwd + "/vendor/" + pkg.Name + "/vendor"
-		err := os.Remove(path)
-		if err != nil && !os.IsNotExist(err) {
-			d.log("Error while wiping nested vendor folders %v\n", err)
-		}
-	}
-}
-
 func (d *Deep) wipeTestFiles(pwd, currentPkg string, packages []Package) {
 	for _, pkg := range packages {
-		path := pwd + "/vendor/" + pkg.Name + "/"
+		path := d.vendoredPath(pwd, pkg) + pathSeparatorString
 		err := filepath.Walk(path, func(path string, f os.FileInfo, err error) error {
 			if err != nil {
 				d.log("Error while wiping test files: %v\n", err)
@@ -223,10 +339,60 @@ func (d *Deep) wipeTestFiles(pwd, currentPkg string, packages []Package) {
 	}
 }
 
+// pruneTestOnly removes the packages needed only by test files which were wiped
+// and returns the remaining packages. A test only package is kept when one of
+// the packages in keepsTests needs it for its test files, or when another kept
+// package needs it for its own code.
+func (d *Deep) pruneTestOnly(pwd, currentPkg string, packages []Package, keepsTests map[string]bool) []Package {
+	kept := map[string]struct{}{currentPkg: {}}
+	for _, pkg := range packages {
+		if !pkg.TestOnly {
+			kept[pkg.Name] = struct{}{}
+		}
+	}
+
+	// Keeping a test only package may be the reason to keep another one
+	for changed := true; changed; {
+		changed = false
+		for _, pkg := range packages {
+			if _, ok := kept[pkg.Name]; ok {
+				continue
+			}
+			for requester, testOnly := range pkg.requesters {
+				if _, ok := kept[requester]; !ok || testOnly && !keepsTests[requester] {
+					continue
+				}
+				kept[pkg.Name] = struct{}{}
+				changed = true
+				break
+			}
+		}
+	}
+
+	var result []Package
+	for _, pkg := range packages {
+		if _, ok := kept[pkg.Name]; ok {
+			result = append(result, pkg)
+			continue
+		}
+
+		vendoredPath := d.vendoredPath(pwd, pkg)
+		if err := os.RemoveAll(vendoredPath); err != nil {
+			d.log("Error while removing test only package: %s %v\n", pkg.Name, err)
+			result = append(result, pkg)
+			continue
+		}
+		d.removeEmptyParents(d.vendorPath(pwd), vendoredPath)
+		d.log("Removed test only package: %s\n", pkg.Name)
+	}
+
+	return result
+}
+
 func (d *Deep) wipeVCS(pwd string, packages []Package) {
 	for _, pkg := range packages {
 		for _, vcsDir := range d.vcsDirs {
-			vcsDirPath := pkg.vendoredPath(pwd) + pathSeparatorString + vcsDir
+			vcsDirPath := d.vendoredPath(pwd, pkg) + pathSeparatorString + vcsDir
 			err := os.RemoveAll(vcsDirPath)
 			if err != nil {
 				d.log("Error while removing vcs dir for package: %s\n", pkg.Name)
@@ -235,98 +401,153 @@ func (d *Deep) wipeVCS(pwd string, packages []Package) {
 	}
 }
 
-func (d *Deep) writeDeepFiles(pwd, currentPkg string, packages []Package) {
-	// TODO We shouldn't have to do this to begin with
-	for idx := range packages {
-		packages[idx].Dependencies = nil
+// writeDeepFiles writes the manifest, with the dependencies needed by the project,
+// and the lock file, with the whole dependency graph
+func (d *Deep) writeDeepFiles(pwd, currentPkg string, packages []Package) error {
+	// Keep the details of the root package from the manifest, when we have one
+	m := &Manifest{
+		Package: Package{
+			Name:    currentPkg,
+			Version: "HEAD",
+		},
 	}
-
-	// TODO improve this when we know how to read the current package
-	p := Package{
-		Name:         currentPkg,
-		Version:      "HEAD",
-		Dependencies: make([]Package, len(packages)),
+	if manifest, err := readManifest(pwd); err == nil {
+		m = manifest
 	}
+	p := m.Package
 
-	if copy(p.Dependencies, packages) != len(packages) {
-		panic("Something went terribly wrong while doing an internal copy of the dependency slice")
+	m.Dependencies = nil
+	for _, pkg := range packages {
+		if pkg.transitive {
+			continue
+		}
+		m.Dependencies = append(m.Dependencies, pkg)
 	}
 
-	m := &Manifest{
-		Package: p,
-	}
-	err := m.writeFile(pwd)
+	err := m.writeFile(d.filesPath(pwd))
 	if err != nil {
-		d.log("Error while marshaling the manifest file.\nGot error: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("could not write the manifest file: %v", err)
 	}
 
-	p.Dependencies = make([]Package, len(packages))
-	if copy(p.Dependencies, packages) != len(packages) {
-		panic("Something went terribly wrong while doing an internal copy of the dependency slice")
-	}
+	p.Dependencies = append([]Package(nil), packages...)
 	l := &Lock{
 		Package: p,
 	}
-	err = l.writeFile(pwd)
+	err = l.writeFile(d.filesPath(pwd))
 	if err != nil {
-		d.log("Error while marshaling the lock file.\nGot error: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("could not write the lock file: %v", err)
 	}
+
+	err = d.writeSums(pwd, packages)
+	if err != nil {
+		return fmt.Errorf("could not write the sum file: %v", err)
+	}
+
+	return nil
 }
 
 // Run will execute all operations needed in order to vendor the the project.
-func (d *Deep) Run(pwd, currentPkg string, keepTypes map[string]struct{}, args []string) {
+// The packages are fetched concurrently and the run stops when ctx is done.
+// When keepTypes is nil, the types of files to strip are taken from the manifest.
+// The new vendor directory is prepared next to the current one which, along with
+// the manifest and lock files, is replaced only when everything succeeds.
+func (d *Deep) Run(ctx context.Context, pwd, currentPkg string, keepTypes map[string]struct{}) error {
 	if currentPkg == "" {
-		d.log("Current package is empty. Are you running on a project from GOPATH?")
-		os.Exit(1)
+		return errors.New("current package is empty, are you running on a project from GOPATH?")
 	}
 
-	packages := d.listPackages(pwd, currentPkg)
+	err := d.begin(pwd)
+	if err != nil {
+		return err
+	}
+	defer d.rollback()
+
+	packages, err := d.listPackages(pwd, currentPkg)
+	if err != nil {
+		return err
+	}
 	if len(packages) == 0 {
 		d.log("No packages found")
-		return
+		return nil
 	}
 
-	d.vendorPackages(pwd, currentPkg, packages)
+	packages, err = d.vendorPackages(ctx, pwd, currentPkg, packages)
+	if err != nil {
+		return err
+	}
 
-	d.readCommitHashes(pwd, currentPkg, packages)
+	packages, err = d.processPackages(pwd, currentPkg, keepTypes, false, packages)
+	if err != nil {
+		return err
+	}
 
-	d.wipeNestedVendor(pwd, currentPkg, packages)
+	err = d.writeDeepFiles(pwd, currentPkg, packages)
+	if err != nil {
+		return err
+	}
 
-	if _, ok := keepTypes["vcs"]; !ok {
-		d.wipeVCS(pwd, packages)
+	// An interrupt while processing the packages still leaves everything untouched
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	if _, ok := keepTypes["test"]; !ok {
-		d.wipeTestFiles(pwd, currentPkg, packages)
+	return d.commit(pwd)
+}
+
+// processPackages checks the packages kept from a previous run against the
+// lock, reads the commit hashes of the freshly vendored packages, removes
+// their vendor directories then strips them of the files which are not needed
+// and computes their digest. The packages left vendored are returned.
+// With keepUnused, the packages fetched are not pruned of their unused
+// packages, see stripPackages.
+func (d *Deep) processPackages(pwd, currentPkg string, keepTypes map[string]struct{}, keepUnused bool, packages []Package) ([]Package, error) {
+	err := d.checkKeptPackages(pwd, packages)
+	if err != nil {
+		return nil, err
 	}
 
-	// TODO implement
-	/*if _, ok := keepTypes["main"]; !ok {
-		d.wipeMainFiles(pwd, currentPkg, packages)
-	}*/
+	d.readCommitHashes(pwd, currentPkg, packages)
+
+	d.flattenVendor(pwd, packages)
 
-	d.writeDeepFiles(pwd, currentPkg, packages)
+	packages = d.stripPackages(pwd, currentPkg, keepTypes, keepUnused, packages)
+
+	d.digestPackages(pwd, packages)
+	return packages, nil
+}
+
+// IncludeTestDependencies vendors the test dependencies of the named packages,
+// or of all the packages when one of the names is "all". The test dependencies
+// of the project are always vendored.
+func (d *Deep) IncludeTestDependencies(names ...string) {
+	for _, name := range names {
+		d.testDeps[name] = struct{}{}
+	}
 }
 
 // New creates a new instance of Deep
 func New(logger Logger) *Deep {
-	vcsDirs := []string{
-		".bzr",
-		".git",
-		".hg",
-		".svn",
+	var vcsDirs []string
+	for _, v := range vcsList {
+		vcsDirs = append(vcsDirs, v.metadataDir())
 	}
 
 	providers := []provider{
 		newDeep(logger),
-		newGoList(logger),
+		newImportScanner(logger),
 	}
 
 	return &Deep{
-		log:       logger,
-		vcsDirs:   vcsDirs,
-		providers: providers,
+		log:             logger,
+		vcsDirs:         vcsDirs,
+		providers:       providers,
+		httpClient:      newHTTPClient(),
+		discoveryScheme: "https",
+		roots:           map[string]repoRoot{},
+		testDeps:        map[string]struct{}{},
+		wipePolicy:      WipeAsk,
+		input:           os.Stdin,
+		jobs:            defaultJobs,
+		cache:           repositoriesCacheDir(),
 	}
 }
@@ -16,6 +16,7 @@ package deep
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 )
 
@@ -24,13 +25,37 @@ import (
 // by Deep
 type Manifest struct {
 	Package
+	Overrides []Override `json:"overrides,omitempty"`
+}
+
+// Override forces the version, the commit or the source of a package regardless
+// of the versions requested by the dependencies of the project
+type Override struct {
+	Name       string `json:"name,omitempty"`
+	Version    string `json:"version,omitempty"`
+	CommitHash string `json:"commit_hash,omitempty"`
+	Source     string `json:"source,omitempty"`
+	VCS        string `json:"vcs,omitempty"`
 }
 
 const manifestFileName = "deep.json"
 
 func (m *Manifest) writeFile(path string) error {
 	for idx := range m.Dependencies {
+		m.Dependencies[idx].Tag = ""
 		m.Dependencies[idx].CommitHash = ""
+		m.Dependencies[idx].Dependencies = nil
+		if override := m.Dependencies[idx].Override; override != nil && override.Source != "" {
+			m.Dependencies[idx].Source = ""
+		}
+		if m.Dependencies[idx].Source == "" {
+			m.Dependencies[idx].VCS = ""
+		}
+		m.Dependencies[idx].Override = nil
+		m.Dependencies[idx].TestOnly = false
+		m.Dependencies[idx].Stripped = nil
+		m.Dependencies[idx].Pruned = nil
+		m.Dependencies[idx].Digest = ""
 	}
 	man, err := json.MarshalIndent(m, "", "  ")
 	if err != nil {
@@ -39,3 +64,24 @@ func (m *Manifest) writeFile(path string) error {
 
 	return ioutil.WriteFile(path+pathSeparatorString+manifestFileName, man, 0644)
 }
+
+func readManifest(path string) (*Manifest, error) {
+	man, err := ioutil.ReadFile(path + pathSeparatorString + manifestFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	err = json.Unmarshal(man, m)
+	if err != nil {
+		return nil, newError(ErrManifestInvalid, "", fmt.Errorf("%s: %v", manifestFileName, err))
+	}
+
+	return m, nil
+}
+
+// forcesVersion reports if the override forces a version or a commit, as opposed
+// to only changing the source of the package
+func (o Override) forcesVersion() bool {
+	return o.Version != "" || o.CommitHash != ""
+}
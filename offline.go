@@ -0,0 +1,40 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"fmt"
+)
+
+// SetOffline forbids any network access. The packages are then vendored from
+// the repositories of the cache only, at the revisions from the lock file when
+// there is one, and fail with ErrOffline when that's not possible.
+func (d *Deep) SetOffline(offline bool) {
+	d.offline = offline
+}
+
+// offlineError reports that the package can't be vendored at its revision
+// without accessing the network
+func (d *Deep) offlineError(pkg Package, reason string) error {
+	revision := pkg.revision()
+	if revision == "" {
+		revision = defaultVersion
+	}
+	return &Error{
+		Kind:    ErrOffline,
+		Package: pkg.Name,
+		Err:     fmt.Errorf("%s, revision %s is needed", reason, revision),
+	}
+}
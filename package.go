@@ -15,65 +15,115 @@
 package deep
 
 import (
-	"path/filepath"
 	"strings"
 )
 
+// defaultVersion is the version used for the packages which don't request one,
+// it matches the highest released version of the package
+const defaultVersion = "*"
+
 // Package defines the package format that Deep understands
 type Package struct {
 	Name         string    `json:"name"`
 	Version      string    `json:"version"`
+	Tag          string    `json:"tag,omitempty"`
 	CommitHash   string    `json:"commit_hash,omitempty"`
 	License      string    `json:"license,omitempty"`
 	Description  string    `json:"description,omitempty"`
 	OSes         []string  `json:"oses,omitempty"`
 	MinGoVer     string    `json:"min_go_ver,omitempty"`
+	Source       string    `json:"source,omitempty"`
+	VCS          string    `json:"vcs,omitempty"`
+	Override     *Override `json:"override,omitempty"`
+	Tests        bool      `json:"tests,omitempty"`
+	TestOnly     bool      `json:"test_only,omitempty"`
+	Strip        []string  `json:"strip,omitempty"`
+	Stripped     []string  `json:"stripped,omitempty"`
+	Pruned       []string  `json:"pruned,omitempty"`
+	Digest       string    `json:"digest,omitempty"`
 	Dependencies []Package `json:"dependencies,omitempty"`
+
+	// transitive is true for the packages which are not needed by the
+	// project directly but only by its dependencies
+	transitive bool
+	// files holds the hashes of the files of the freshly vendored package
+	files map[string]string
+	// fetched is true for the packages vendored again by the current run
+	fetched bool
+	// requesters holds the packages which need the test only package, with
+	// true for the ones which need it only for their test files
+	requesters map[string]bool
 }
 
+// isStdlib checks if the package is part of the standard library of the active
+// Go toolchain
 func (p Package) isStdlib() bool {
-	_, ok := stdlibPackages[p.Name]
+	_, ok := stdlibSet()[p.Name]
 	return ok
 }
 
-// isSubPackage checks if the current package is a package contained by
-// another package.
-//
-// We need some better detection on this one
-func (p Package) isSubPackage() bool {
-	if strings.HasPrefix(p.Name, "github.com") {
-		// github.com/dlsniper/deep/cmd is contained by github.com/dlsniper/deep
-		return strings.Count(p.Name, "/") > 2
+func (p Package) isThirdParty(currentPkg string) bool {
+	if p.Name == currentPkg || strings.HasPrefix(p.Name, currentPkg+"/") {
+		return false
 	}
 
-	return false
+	// Standard library packages are not considered third-party packages
+	if p.isStdlib() {
+		return false
+	}
+
+	return true
 }
 
-func (p Package) isRootPackage(currentPkg string) bool {
-	if strings.HasPrefix(p.Name, currentPkg+"/vendor") {
-		return false
+// revision returns the revision that should be checked out for the package,
+// preferring the locked commit hash over the requested version
+func (p Package) revision() string {
+	if p.CommitHash != "" {
+		return p.CommitHash
 	}
 
-	return !p.isSubPackage()
+	return p.Version
 }
 
-func (p Package) isThirdParty(currentPkg string) bool {
-	if p.Name == currentPkg {
-		return false
+// setDependency adds the dependency to the package or replaces the existing
+// dependency with the same name
+func (p *Package) setDependency(dep Package) {
+	for idx := range p.Dependencies {
+		if p.Dependencies[idx].Name == dep.Name {
+			p.Dependencies[idx] = dep
+			return
+		}
 	}
 
-	// Standard library packages are not considered third-party packages
-	if p.isStdlib() {
-		return false
+	p.Dependencies = append(p.Dependencies, dep)
+}
+
+// removeDependency removes the dependency with the given name from the package
+// and reports if it was found
+func (p *Package) removeDependency(name string) bool {
+	for idx := range p.Dependencies {
+		if p.Dependencies[idx].Name == name {
+			p.Dependencies = append(p.Dependencies[:idx], p.Dependencies[idx+1:]...)
+			return true
+		}
 	}
 
-	return true
+	return false
 }
 
-func (p Package) vendoredPath(pwd string) string {
-	return filepath.Clean(pwd + pathSeparatorString + "vendor" + pathSeparatorString + p.Name)
+// dependency returns the dependency with the given name, if any
+func (p Package) dependency(name string) (Package, bool) {
+	for _, dep := range p.Dependencies {
+		if dep.Name == name {
+			return dep, true
+		}
+	}
+
+	return Package{}, false
 }
 
+// stdlibPackages is the standard library of Go 1.8, used only when the active
+// Go toolchain can't be queried
 var stdlibPackages = map[string]struct{}{
 	"archive/tar":                                 {},
 	"archive/zip":                                 {},
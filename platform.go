@@ -0,0 +1,64 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"go/build"
+	"strings"
+)
+
+// buildContexts returns the build contexts for the OS/arch combinations listed
+// in the manifest. Each entry is either goos/goarch or goos alone, in which case
+// the current architecture is used. When the list is empty, only the current
+// OS/arch combination is used.
+func buildContexts(oses []string) []*build.Context {
+	var contexts []*build.Context
+	seen := map[string]struct{}{}
+	for _, entry := range oses {
+		goos, goarch := strings.TrimSpace(entry), build.Default.GOARCH
+		if idx := strings.Index(goos, "/"); idx != -1 {
+			goos, goarch = goos[:idx], goos[idx+1:]
+		}
+		if goos == "" || goarch == "" {
+			continue
+		}
+		if _, ok := seen[goos+"/"+goarch]; ok {
+			continue
+		}
+		seen[goos+"/"+goarch] = struct{}{}
+
+		ctx := build.Default
+		ctx.GOOS, ctx.GOARCH = goos, goarch
+		// cgo is assumed to be available so the imports of the cgo files are found too
+		ctx.CgoEnabled = true
+		contexts = append(contexts, &ctx)
+	}
+
+	if len(contexts) == 0 {
+		contexts = append(contexts, &build.Default)
+	}
+
+	return contexts
+}
+
+// manifestContexts returns the build contexts for the manifest of the project,
+// if any
+func manifestContexts(manifest *Manifest) []*build.Context {
+	if manifest == nil {
+		return buildContexts(nil)
+	}
+
+	return buildContexts(manifest.OSes)
+}
@@ -0,0 +1,250 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// reachablePackages returns the directories of the vendored packages imported,
+// directly or not, by the project for any of the build contexts. The imports of
+// the test files are followed too, the stripped test files having none.
+func (d *Deep) reachablePackages(pwd string, contexts []*build.Context) (map[string]struct{}, error) {
+	vendorPath := d.vendorPath(pwd)
+	reachable := map[string]struct{}{}
+	var queue []string
+	visit := func(pkg *build.Package) {
+		for _, imp := range packageImports(pkg, true) {
+			dir := filepath.Join(vendorPath, filepath.FromSlash(imp))
+			if _, ok := reachable[dir]; ok {
+				continue
+			}
+			if f, err := os.Stat(dir); err != nil || !f.IsDir() {
+				continue
+			}
+			reachable[dir] = struct{}{}
+			queue = append(queue, dir)
+		}
+	}
+
+	err := walkPackages(pwd, contexts, nil, visit)
+	if err != nil {
+		return nil, err
+	}
+
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		for _, ctx := range contexts {
+			pkg, err := ctx.ImportDir(dir, build.ImportComment)
+			if err != nil {
+				if _, ok := err.(*build.NoGoError); ok {
+					continue
+				}
+			}
+			visit(pkg)
+		}
+	}
+
+	return reachable, nil
+}
+
+// pruneUnused removes the files of the packages not reachable from the project,
+// along with the directories without Go files they hold. The license files are
+// always kept. The number of bytes saved is returned along with the directories
+// pruned from each package.
+func (d *Deep) pruneUnused(pwd string, packages []Package) (int64, map[string][]string) {
+	pruned := map[string][]string{}
+	if len(packages) == 0 {
+		return 0, pruned
+	}
+
+	manifest, _ := readManifest(pwd)
+	reachable, err := d.reachablePackages(pwd, manifestContexts(manifest))
+	if err != nil {
+		d.log("Error while finding the unused packages: %v\n", err)
+		return 0, pruned
+	}
+
+	var saved int64
+	for _, pkg := range packages {
+		pkgSaved, dirs, err := d.pruneRepository(d.vendoredPath(pwd, pkg), reachable)
+		if err != nil {
+			d.log("Error while removing the unused packages of %s: %v\n", pkg.Name, err)
+		}
+		saved += pkgSaved
+		pruned[pkg.Name] = dirs
+	}
+
+	return saved, pruned
+}
+
+// pruneRepository removes the unreachable packages of a vendored repository
+// and returns their slash separated directories, relative to the repository.
+// A directory without Go files belongs to the closest package above it.
+func (d *Deep) pruneRepository(root string, reachable map[string]struct{}) (int64, []string, error) {
+	used := map[string]bool{}
+	var unused []os.FileInfo
+	var unusedPaths []string
+	var pruned []string
+	err := filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !f.IsDir() {
+			return nil
+		}
+		if path != root && (f.Name() == "vendor" || d.isVCSDir(f.Name())) {
+			return filepath.SkipDir
+		}
+
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			return err
+		}
+
+		goFiles := hasGoFiles(files)
+		if goFiles {
+			_, used[path] = reachable[path]
+		} else if path != root {
+			used[path] = used[filepath.Dir(path)]
+		}
+		if used[path] {
+			return nil
+		}
+
+		if goFiles {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			pruned = append(pruned, filepath.ToSlash(rel))
+		}
+
+		for _, file := range files {
+			if file.IsDir() || isLegalFile(file.Name()) {
+				continue
+			}
+			unused = append(unused, file)
+			unusedPaths = append(unusedPaths, filepath.Join(path, file.Name()))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var saved int64
+	for idx, path := range unusedPaths {
+		if err := os.Remove(path); err != nil {
+			return saved, pruned, err
+		}
+		saved += unused[idx].Size()
+	}
+
+	return saved, pruned, nil
+}
+
+// prunedImportPath returns the import path of a directory pruned from the package
+func prunedImportPath(pkg Package, dir string) string {
+	if dir == "." {
+		return pkg.Name
+	}
+	return pkg.Name + "/" + dir
+}
+
+// mergePruned returns the sorted directories found in either list
+func mergePruned(a, b []string) []string {
+	seen := map[string]struct{}{}
+	var result []string
+	for _, dir := range append(append([]string{}, a...), b...) {
+		if _, ok := seen[dir]; ok {
+			continue
+		}
+		seen[dir] = struct{}{}
+		result = append(result, dir)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// prunedImports returns the packages kept from a previous run which had some of
+// their directories pruned that are imported again, by the project or by the
+// vendored packages. They have to be vendored again since their vendored copy
+// still matches the lock.
+func (d *Deep) prunedImports(pwd string, r *resolution) ([]int, error) {
+	var candidates []int
+	for idx, pkg := range r.packages {
+		if pkg.fetched {
+			continue
+		}
+		if locked, ok := r.lock.dependency(pkg.Name); ok && len(locked.Pruned) > 0 {
+			candidates = append(candidates, idx)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	imported := map[string]struct{}{}
+	for _, dir := range []string{pwd, d.vendorPath(pwd)} {
+		imports, err := importsOf(dir, r.contexts, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, imp := range imports {
+			imported[imp] = struct{}{}
+		}
+	}
+
+	var result []int
+	for _, idx := range candidates {
+		pkg := r.packages[idx]
+		locked, _ := r.lock.dependency(pkg.Name)
+		for _, dir := range locked.Pruned {
+			importPath := prunedImportPath(pkg, dir)
+			if _, ok := imported[importPath]; ok {
+				d.log("Vendoring %s again since %s, which was pruned, is imported\n", pkg.Name, importPath)
+				result = append(result, idx)
+				break
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (d *Deep) isVCSDir(name string) bool {
+	for _, vcsDir := range d.vcsDirs {
+		if name == vcsDir {
+			return true
+		}
+	}
+	return false
+}
+
+func hasGoFiles(files []os.FileInfo) bool {
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".go") {
+			return true
+		}
+	}
+	return false
+}
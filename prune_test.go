@@ -0,0 +1,193 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPruneRepository(t *testing.T) {
+	root, err := ioutil.TempDir("", "deep-prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeTestFiles(t, root, map[string]string{
+		"LICENSE":            "license",
+		"lib.go":             "package lib",
+		"used/used.go":       "package used",
+		"used/assets/a.json": "{}",
+		"unused/unused.go":   "package unused",
+		"unused/deep/d.go":   "package deep",
+		"unused/data/d.json": "{}",
+	})
+
+	reachable := map[string]struct{}{
+		root:                        {},
+		filepath.Join(root, "used"): {},
+	}
+	d := New(func(string, ...interface{}) {})
+	saved, pruned, err := d.pruneRepository(root, reachable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"unused", "unused/deep"}; !reflect.DeepEqual(pruned, want) {
+		t.Errorf("pruned: got %v, want %v", pruned, want)
+	}
+	if want := int64(len("package unused") + len("package deep") + len("{}")); saved != want {
+		t.Errorf("saved: got %d, want %d", saved, want)
+	}
+	for _, name := range []string{"LICENSE", "lib.go", "used/used.go", "used/assets/a.json"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			t.Errorf("%s was removed", name)
+		}
+	}
+	for _, name := range []string{"unused/unused.go", "unused/deep/d.go", "unused/data/d.json"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+			t.Errorf("%s was kept", name)
+		}
+	}
+}
+
+// TestPruneThenImport checks that a package pruned from a vendored repository
+// is vendored again once the project imports it
+func TestPruneThenImport(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "deep-prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := createTestRepo(t, dir, "lib", map[string]string{
+		"lib.go":     "package lib\n",
+		"sub/sub.go": "package sub\n",
+	})
+
+	pwd := filepath.Join(dir, "src", "example.com", "app")
+	writeTestFiles(t, pwd, map[string]string{
+		manifestFileName: `{"dependencies": [{"name": "example.com/lib", "version": "*", "source": "file://` + filepath.ToSlash(repo) + `"}]}`,
+		"main.go":        "package main\n\nimport _ \"example.com/lib\"\n\nfunc main() {}\n",
+	})
+
+	run := func() *Lock {
+		d := New(func(string, ...interface{}) {})
+		d.SetCacheDir(filepath.Join(dir, "cache"))
+		d.input = strings.NewReader("")
+		if err := d.Run(context.Background(), pwd, "example.com/app", nil); err != nil {
+			t.Fatal(err)
+		}
+		lock, err := readLock(pwd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lock
+	}
+
+	subPath := filepath.Join(pwd, "vendor", "example.com", "lib", "sub", "sub.go")
+	lock := run()
+	if _, err := os.Stat(subPath); err == nil {
+		t.Fatal("the unused package example.com/lib/sub was not pruned")
+	}
+	if locked, _ := lock.dependency("example.com/lib"); !reflect.DeepEqual(locked.Pruned, []string{"sub"}) {
+		t.Fatalf("pruned: got %v, want [sub]", locked.Pruned)
+	}
+
+	writeTestFiles(t, pwd, map[string]string{
+		"main.go": "package main\n\nimport (\n\t_ \"example.com/lib\"\n\t_ \"example.com/lib/sub\"\n)\n\nfunc main() {}\n",
+	})
+	lock = run()
+	if _, err := os.Stat(subPath); err != nil {
+		t.Fatal("example.com/lib/sub was not vendored again once imported")
+	}
+	if locked, _ := lock.dependency("example.com/lib"); len(locked.Pruned) != 0 {
+		t.Fatalf("pruned: got %v, want none", locked.Pruned)
+	}
+}
+
+// TestAddKeepsUnused checks that the package added is not pruned before the
+// project imports it
+func TestAddKeepsUnused(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "deep-prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := createTestRepo(t, dir, "lib", map[string]string{
+		"lib.go":     "package lib\n",
+		"sub/sub.go": "package sub\n",
+	})
+
+	pwd := filepath.Join(dir, "src", "example.com", "app")
+	// The manifest only gives the source, the project doesn't import the package
+	writeTestFiles(t, pwd, map[string]string{
+		manifestFileName: `{"dependencies": [{"name": "example.com/lib", "version": "*", "source": "file://` + filepath.ToSlash(repo) + `"}]}`,
+		"main.go":        "package main\n\nfunc main() {}\n",
+	})
+
+	d := New(func(string, ...interface{}) {})
+	d.SetCacheDir(filepath.Join(dir, "cache"))
+	d.input = strings.NewReader("")
+	if err := d.Add(context.Background(), pwd, "example.com/app", nil, "example.com/lib"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"lib.go", "sub/sub.go"} {
+		if _, err := os.Stat(filepath.Join(pwd, "vendor", "example.com", "lib", filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s was pruned", name)
+		}
+	}
+}
+
+// createTestRepo creates a git repository named name under dir, holding the
+// files committed once, and returns its path
+func createTestRepo(t *testing.T, dir, name string, files map[string]string) string {
+	repo := filepath.Join(dir, name)
+	writeTestFiles(t, repo, files)
+	runTestCommand(t, dir, "git", "init", "-q", repo)
+	runTestCommand(t, repo, "git", "add", ".")
+	runTestCommand(t, repo, "git", "commit", "-q", "-m", name)
+	return repo
+}
+
+// writeTestFiles writes the files, by their slash separated path, under dir
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// importersOf returns the files of the project and of the other vendored packages
+// which still import the package
+func (d *Deep) importersOf(pwd string, contexts []*build.Context, pkg Package) ([]string, error) {
+	vendorPath := d.vendorPath(pwd)
+	vendoredPath := d.vendoredPath(pwd, pkg)
+
+	importers, err := importingFiles(pwd, contexts, nil, pkg.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	pathExists, err := d.pathExists(vendorPath)
+	if err != nil || !pathExists {
+		return importers, err
+	}
+
+	vendorImporters, err := importingFiles(vendorPath, contexts, func(path string) bool {
+		return path == vendoredPath
+	}, pkg.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, importer := range vendorImporters {
+		importers = append(importers, d.displayPath(importer))
+	}
+	return importers, nil
+}
+
+// Remove removes the package from the manifest and lock files and deletes its
+// vendored copy. A package inside a repository removes the whole repository.
+// Unless forced, the removal is refused while the project or other vendored
+// packages still import the package.
+func (d *Deep) Remove(pwd, currentPkg, name string, force bool) error {
+	if currentPkg == "" {
+		return errors.New("current package is empty, are you running on a project from GOPATH?")
+	}
+
+	pkg := Package{Name: strings.Trim(name, "/")}
+	if pkg.Name == "" {
+		return errors.New("missing package name")
+	}
+
+	manifest, lock, err := d.loadProject(pwd, currentPkg)
+	if err != nil {
+		return err
+	}
+
+	err = d.begin(pwd)
+	if err != nil {
+		return err
+	}
+	defer d.rollback()
+
+	// The dependencies are stored by their repository root
+	d.addKnownPackages(lock.Dependencies)
+	d.addKnownPackages(manifest.Dependencies)
+	pkg = d.rootPackage(pwd, pkg)
+	if pkg.Name != strings.Trim(name, "/") {
+		d.log("Removing %s, the repository of %s\n", pkg.Name, name)
+	}
+
+	if _, ok := manifest.dependency(pkg.Name); !ok {
+		if _, ok := lock.dependency(pkg.Name); !ok {
+			return newError(ErrPackageNotFound, pkg.Name, errors.New("the package is not a dependency of the project"))
+		}
+	}
+
+	importers, err := d.importersOf(pwd, manifestContexts(manifest), pkg)
+	if err != nil {
+		return err
+	}
+
+	if len(importers) > 0 {
+		if !force {
+			return fmt.Errorf("package %s is still imported by:\n\t%s", pkg.Name, strings.Join(importers, "\n\t"))
+		}
+		d.log("Removing package %s which is still imported by:\n\t%s\n", pkg.Name, strings.Join(importers, "\n\t"))
+	}
+
+	manifest.removeDependency(pkg.Name)
+	lock.removeDependency(pkg.Name)
+	vendoredPath := d.vendoredPath(pwd, pkg)
+	err = os.RemoveAll(vendoredPath)
+	if err != nil {
+		return err
+	}
+	d.removeEmptyParents(d.vendorPath(pwd), vendoredPath)
+
+	err = manifest.writeFile(d.filesPath(pwd))
+	if err != nil {
+		return err
+	}
+
+	err = lock.writeFile(d.filesPath(pwd))
+	if err != nil {
+		return err
+	}
+
+	err = d.writeSums(pwd, lock.Dependencies)
+	if err != nil {
+		return err
+	}
+
+	return d.commit(pwd)
+}
+
+// removeEmptyParents removes the empty directories left between the path
+// and the vendor directory
+func (d *Deep) removeEmptyParents(vendorPath, path string) {
+	for dir := filepath.Dir(path); dir != vendorPath && strings.HasPrefix(dir, vendorPath); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
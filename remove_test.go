@@ -0,0 +1,90 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemove(t *testing.T) {
+	tests := []struct {
+		name    string
+		removed string
+		err     error
+	}{
+		{name: "example.com/lib", removed: "example.com/lib"},
+		{name: "example.com/lib/", removed: "example.com/lib"},
+		// A package inside the repository removes the whole repository
+		{name: "example.com/lib/sub", removed: "example.com/lib"},
+		// Being vendored is not enough to be a dependency, the .invalid domain
+		// keeps the discovery of its repository root from reaching the network
+		{name: "other.invalid/pkg", err: ErrPackageNotFound},
+	}
+
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "deep-remove")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		pwd := filepath.Join(dir, "src", "example.com", "app")
+		writeTestFiles(t, pwd, map[string]string{
+			manifestFileName:                    `{"name": "example.com/app", "dependencies": [{"name": "example.com/lib", "version": "*"}]}`,
+			lockFileName:                        `{"name": "example.com/app", "dependencies": [{"name": "example.com/lib", "version": "*", "tag": "v1.0.0"}]}`,
+			"main.go":                           "package main\n\nfunc main() {}\n",
+			"vendor/example.com/lib/lib.go":     "package lib\n",
+			"vendor/example.com/lib/sub/sub.go": "package sub\n",
+			"vendor/other.invalid/pkg/pkg.go":   "package pkg\n",
+		})
+
+		d := New(func(string, ...interface{}) {})
+		err = d.Remove(pwd, "example.com/app", tt.name, false)
+		if tt.err != nil {
+			if !IsKind(err, tt.err) {
+				t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+			}
+			if _, err := os.Stat(filepath.Join(pwd, "vendor", filepath.FromSlash(tt.name))); err != nil {
+				t.Errorf("%s: the vendored package was removed", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(pwd, "vendor", filepath.FromSlash(tt.removed))); err == nil {
+			t.Errorf("%s: %s is still vendored", tt.name, tt.removed)
+		}
+		manifest, err := readManifest(pwd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := manifest.dependency(tt.removed); ok {
+			t.Errorf("%s: %s is still in the manifest", tt.name, tt.removed)
+		}
+		lock, err := readLock(pwd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := lock.dependency(tt.removed); ok {
+			t.Errorf("%s: %s is still in the lock", tt.name, tt.removed)
+		}
+	}
+}
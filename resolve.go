@@ -0,0 +1,583 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/build"
+	"sort"
+	"strings"
+)
+
+type (
+	// Requirement is a version of a package requested by the project or by
+	// one of its dependencies
+	Requirement struct {
+		Requester string
+		Version   string
+		// Chain is the import chain, starting with the project, which
+		// leads to the requester
+		Chain []string
+	}
+
+	// Conflict holds the requirements of a package which can't be satisfied
+	// at the same time along with a version suggested to solve them
+	Conflict struct {
+		Package      string
+		Requirements []Requirement
+		Suggested    string
+	}
+
+	// ConflictError is returned when the versions requested for packages conflict
+	ConflictError struct {
+		Conflicts []Conflict
+	}
+
+	// resolution tracks the packages needed by the project and who requested them
+	resolution struct {
+		currentPkg string
+		lock       *Lock
+		// packages are the packages vendored by the current run
+		packages []Package
+		// existing are the packages which are already vendored and left untouched
+		existing     map[string]Package
+		requirements map[string][]Requirement
+		chains       map[string][]string
+		overrides    map[string]Override
+		// contexts are the build contexts used to find the imports of the packages
+		contexts []*build.Context
+		// imports holds, for each requester, the requested packages and if they
+		// were requested only by test files
+		imports map[string]map[string]bool
+	}
+)
+
+// Is reports if the error is of the given kind, which is always ErrConflict
+func (e *ConflictError) Is(kind error) bool {
+	return kind == ErrConflict
+}
+
+func (e *ConflictError) Error() string {
+	buf := &bytes.Buffer{}
+	for idx, conflict := range e.Conflicts {
+		if idx > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "Version conflict for %s:\n", conflict.Package)
+		for _, req := range conflict.Requirements {
+			fmt.Fprintf(buf, "  %s requires %s\n", req.Requester, req.Version)
+			fmt.Fprintf(buf, "    via %s\n", strings.Join(req.Chain, " -> "))
+		}
+		fmt.Fprintf(buf, "Suggested override for %s:\n", manifestFileName)
+		fmt.Fprintf(buf, "  \"overrides\": [{\"name\": %q, \"version\": %q}]\n", conflict.Package, conflict.Suggested)
+	}
+	return buf.String()
+}
+
+func newResolution(currentPkg string, manifest *Manifest, lock *Lock) *resolution {
+	if lock == nil {
+		lock = &Lock{}
+	}
+
+	overrides := map[string]Override{}
+	contexts := manifestContexts(manifest)
+	if manifest != nil {
+		for _, override := range manifest.Overrides {
+			overrides[override.Name] = override
+		}
+	}
+
+	return &resolution{
+		currentPkg:   currentPkg,
+		lock:         lock,
+		existing:     map[string]Package{},
+		requirements: map[string][]Requirement{},
+		chains:       map[string][]string{},
+		overrides:    overrides,
+		contexts:     contexts,
+		imports:      map[string]map[string]bool{},
+	}
+}
+
+// addExisting registers the packages already vendored, as recorded in the lock
+// file, and their requirements. The skipped package is not registered as it's
+// going to be vendored again.
+func (r *resolution) addExisting(manifest *Manifest, skip string) {
+	for _, dep := range manifest.Dependencies {
+		if dep.Name == skip {
+			continue
+		}
+		r.addRequirement(r.currentPkg, dep.Name, dep.Version)
+	}
+
+	for _, pkg := range r.lock.Dependencies {
+		if pkg.Name == skip {
+			continue
+		}
+		r.existing[pkg.Name] = pkg
+		for _, dep := range pkg.Dependencies {
+			r.addRequirement(pkg.Name, dep.Name, dep.Version)
+		}
+	}
+}
+
+func (r *resolution) chain(name string) []string {
+	if name == r.currentPkg {
+		return []string{r.currentPkg}
+	}
+	if chain, ok := r.chains[name]; ok {
+		return chain
+	}
+	return []string{r.currentPkg, name}
+}
+
+func (r *resolution) addRequirement(requester, name, version string) {
+	chain := r.chain(requester)
+	r.requirements[name] = append(r.requirements[name], Requirement{
+		Requester: requester,
+		Version:   version,
+		Chain:     chain,
+	})
+
+	if _, ok := r.chains[name]; !ok {
+		r.chains[name] = append(append([]string{}, chain...), name)
+	}
+}
+
+// selected returns the package selected for the given name
+func (r *resolution) selected(name string) (int, Package, bool) {
+	for idx, pkg := range r.packages {
+		if pkg.Name == name {
+			return idx, pkg, true
+		}
+	}
+
+	pkg, ok := r.existing[name]
+	return -1, pkg, ok
+}
+
+// require records the package as requested by the requester. When the package is
+// not known yet it's added to the packages to vendor, pinned to the revision from
+// the lock file when the requested version and the override did not change, and
+// true is returned.
+func (r *resolution) require(requester string, pkg Package) bool {
+	r.addRequirement(requester, pkg.Name, pkg.Version)
+	r.addImport(requester, pkg.Name, pkg.TestOnly)
+	if _, _, ok := r.selected(pkg.Name); ok {
+		return false
+	}
+
+	pkg.Override = nil
+	if override, ok := r.overrides[pkg.Name]; ok {
+		override.Name = ""
+		pkg.Override = &override
+		pkg.Tag, pkg.CommitHash = "", override.CommitHash
+		if override.Source != "" {
+			pkg.Source = override.Source
+			pkg.VCS = override.VCS
+		}
+	}
+
+	if pkg.CommitHash == "" {
+		lockedPkg, ok := r.lock.dependency(pkg.Name)
+		if ok && lockedPkg.Version == pkg.Version && sameOverride(lockedPkg.Override, pkg.Override) {
+			pkg.Tag = lockedPkg.Tag
+			pkg.CommitHash = lockedPkg.CommitHash
+		}
+	}
+	pkg.transitive = requester != r.currentPkg
+	r.packages = append(r.packages, pkg)
+
+	return true
+}
+
+// addImport records that the requester needs the package, possibly only for
+// its test files
+func (r *resolution) addImport(requester, name string, testOnly bool) {
+	if r.imports[requester] == nil {
+		r.imports[requester] = map[string]bool{}
+	}
+	if previous, ok := r.imports[requester][name]; ok {
+		testOnly = testOnly && previous
+	}
+	r.imports[requester][name] = testOnly
+}
+
+// markTestOnly marks the packages which are needed only by the test files of
+// the vendored packages, directly or through other test only packages
+func (r *resolution) markTestOnly() {
+	needed := map[string]struct{}{r.currentPkg: {}}
+	queue := []string{r.currentPkg}
+	for name, pkg := range r.existing {
+		if !pkg.TestOnly {
+			needed[name] = struct{}{}
+			queue = append(queue, name)
+		}
+	}
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for dep, testOnly := range r.imports[name] {
+			if _, ok := needed[dep]; ok || testOnly {
+				continue
+			}
+			needed[dep] = struct{}{}
+			queue = append(queue, dep)
+		}
+	}
+
+	for idx := range r.packages {
+		name := r.packages[idx].Name
+		_, ok := needed[name]
+		r.packages[idx].TestOnly = !ok
+		r.packages[idx].requesters = nil
+		if ok {
+			continue
+		}
+
+		// The test only packages are pruned according to the ones needing them
+		requesters := map[string]bool{}
+		for requester, imports := range r.imports {
+			if testOnly, ok := imports[name]; ok {
+				requesters[requester] = testOnly
+			}
+		}
+		r.packages[idx].requesters = requesters
+	}
+}
+
+func sameOverride(a, b *Override) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// versions returns all the versions requested for the package, or only the
+// version forced by the override
+func (r *resolution) versions(name string) []string {
+	if override, ok := r.overrides[name]; ok && override.Version != "" {
+		return []string{override.Version}
+	}
+
+	var result []string
+	for _, req := range r.requirements[name] {
+		result = append(result, req.Version)
+	}
+	return result
+}
+
+// isDefaultVersion reports if the version accepts any revision of the package.
+// HEAD, used by the manifests written before the default version was *, is
+// considered the same.
+func isDefaultVersion(version string) bool {
+	return version == defaultVersion || version == "HEAD"
+}
+
+// satisfies reports if the selected package satisfies the requested version
+func satisfies(pkg Package, version string) bool {
+	if version == pkg.Version || isDefaultVersion(version) {
+		return true
+	}
+
+	constraint, err := parseConstraint(version)
+	if err != nil {
+		return version == pkg.Tag || pkg.CommitHash != "" && strings.HasPrefix(pkg.CommitHash, version)
+	}
+
+	v, err := parseSemver(pkg.Tag)
+	return err == nil && constraint.matches(v)
+}
+
+// highestMatchingTag returns the highest tag which satisfies all the version
+// constraints. Versions which are not constraints are ignored.
+func highestMatchingTag(tags []string, versions []string) (string, bool) {
+	var constraint versionConstraint
+	for _, version := range versions {
+		c, err := parseConstraint(version)
+		if err != nil {
+			continue
+		}
+		if constraint == nil {
+			constraint = c
+			continue
+		}
+		constraint = constraint.and(c)
+	}
+
+	if constraint == nil {
+		return "", false
+	}
+
+	return constraint.highestTag(tags)
+}
+
+// and returns a constraint satisfied only when both constraints are satisfied
+func (c versionConstraint) and(o versionConstraint) versionConstraint {
+	var result versionConstraint
+	for _, a := range c {
+		for _, b := range o {
+			terms := append(append([]versionTerm{}, a...), b...)
+			result = append(result, terms)
+		}
+	}
+	return result
+}
+
+// repositoryTags returns the tags of the repository vendored at path
+func repositoryTags(path string) ([]string, error) {
+	v, ok := vcsForDir(path)
+	if !ok {
+		return nil, fmt.Errorf("no repository found at %s", path)
+	}
+
+	return v.tags(path)
+}
+
+// addRequestedDependencies reads the dependencies requested by the manifest of the
+// vendored package and records them, with their requested versions, as the package
+// dependencies. The dependencies which are not known yet are added to the packages
+// to vendor as transitive dependencies while the ones already vendored are
+// checked out again, when possible, to satisfy the new requirement.
+func (d *Deep) addRequestedDependencies(ctx context.Context, pwd string, r *resolution, idx int) error {
+	pkg := r.packages[idx]
+	deps, err := lockedDependencies(d.vendoredPath(pwd, pkg))
+	if err != nil {
+		return newError(ErrManifestInvalid, pkg.Name, err)
+	}
+
+	var requested []Package
+	for _, dep := range deps {
+		requested = append(requested, Package{
+			Name:    dep.Name,
+			Version: dep.Version,
+		})
+
+		// The test dependencies and the files to strip are decided by the project
+		dep.Tests, dep.Strip = false, nil
+		if r.require(pkg.Name, dep) {
+			continue
+		}
+
+		err := d.reconcile(ctx, pwd, r, dep.Name)
+		if err != nil {
+			return err
+		}
+	}
+	r.packages[idx].Dependencies = requested
+
+	return nil
+}
+
+// reconcile checks out another version of an already vendored package when its
+// current version does not satisfy all the requirements but another one does
+func (d *Deep) reconcile(ctx context.Context, pwd string, r *resolution, name string) error {
+	idx, pkg, ok := r.selected(name)
+	if !ok || idx == -1 {
+		return nil
+	}
+
+	if override, ok := r.overrides[name]; ok && override.forcesVersion() {
+		return nil
+	}
+
+	versions := r.versions(name)
+	satisfied := true
+	for _, version := range versions {
+		satisfied = satisfied && satisfies(pkg, version)
+	}
+	if satisfied {
+		return nil
+	}
+
+	vendoredPath := d.vendoredPath(pwd, pkg)
+	v, ok := vcsForDir(vendoredPath)
+	if !ok {
+		return nil
+	}
+
+	tags, err := v.tags(vendoredPath)
+	if err != nil {
+		return err
+	}
+
+	tag, ok := highestMatchingTag(tags, versions)
+	if !ok || tag == pkg.Tag {
+		return nil
+	}
+
+	err = v.checkout(ctx, vendoredPath, v.tagRevision(tag))
+	if err != nil {
+		return err
+	}
+
+	r.packages[idx].Tag = tag
+	r.packages[idx].CommitHash = ""
+	return nil
+}
+
+// conflicts returns the packages whose selected version does not satisfy all
+// the versions requested for them
+func (d *Deep) conflicts(pwd string, r *resolution) []Conflict {
+	names := make([]string, 0, len(r.requirements))
+	for name := range r.requirements {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result []Conflict
+	for _, name := range names {
+		_, pkg, ok := r.selected(name)
+		if !ok {
+			continue
+		}
+		if override, ok := r.overrides[name]; ok && override.forcesVersion() {
+			continue
+		}
+
+		satisfied := true
+		for _, req := range r.requirements[name] {
+			satisfied = satisfied && satisfies(pkg, req.Version)
+		}
+		if satisfied {
+			continue
+		}
+
+		result = append(result, Conflict{
+			Package:      name,
+			Requirements: r.requirements[name],
+			Suggested:    d.suggestVersion(pwd, r, pkg),
+		})
+	}
+
+	return result
+}
+
+// unneededOverrides returns warnings for the overrides which are not needed anymore,
+// either because nothing requires the package or because the requested versions
+// don't conflict with each other
+func (d *Deep) unneededOverrides(pwd string, r *resolution) []string {
+	names := make([]string, 0, len(r.overrides))
+	for name := range r.overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result []string
+	for _, name := range names {
+		reqs := r.requirements[name]
+		if len(reqs) == 0 {
+			result = append(result, fmt.Sprintf("The override for %s is not needed anymore, no package requires it", name))
+			continue
+		}
+		if !r.overrides[name].forcesVersion() {
+			continue
+		}
+
+		var versions []string
+		for _, req := range reqs {
+			versions = append(versions, req.Version)
+		}
+		if !d.compatibleVersions(pwd, r, name, versions) {
+			continue
+		}
+
+		result = append(result, fmt.Sprintf("The override for %s is not needed anymore, the requested versions don't conflict", name))
+	}
+
+	return result
+}
+
+// compatibleVersions reports if the versions requested for a package can be
+// satisfied at the same time
+func (d *Deep) compatibleVersions(pwd string, r *resolution, name string, versions []string) bool {
+	same := true
+	for _, version := range versions {
+		same = same && (version == versions[0] || isDefaultVersion(version))
+	}
+	if same {
+		return true
+	}
+
+	_, pkg, _ := r.selected(name)
+	tags, err := repositoryTags(d.vendoredPath(pwd, pkg))
+	if err != nil {
+		return false
+	}
+
+	for _, version := range versions {
+		if !isVersionConstraint(version) {
+			return false
+		}
+	}
+
+	_, ok := highestMatchingTag(tags, versions)
+	return ok
+}
+
+// suggestVersion returns the highest tag which satisfies the most requirements,
+// giving priority to the one requested by the project itself
+func (d *Deep) suggestVersion(pwd string, r *resolution, pkg Package) string {
+	reqs := r.requirements[pkg.Name]
+	tags, err := repositoryTags(d.vendoredPath(pwd, pkg))
+	if err != nil || len(tags) == 0 {
+		// Without tags, suggest the most specific version requested
+		suggested := pkg.Version
+		for _, req := range reqs {
+			if isDefaultVersion(req.Version) {
+				continue
+			}
+			if req.Requester == r.currentPkg {
+				return req.Version
+			}
+			if isDefaultVersion(suggested) || suggested == pkg.Version {
+				suggested = req.Version
+			}
+		}
+		return suggested
+	}
+
+	for _, req := range reqs {
+		if req.Requester != r.currentPkg {
+			continue
+		}
+		if tag, ok := highestMatchingTag(tags, []string{req.Version}); ok {
+			return tag
+		}
+	}
+
+	best, bestCount := pkg.Version, 0
+	for _, req := range reqs {
+		tag, ok := highestMatchingTag(tags, []string{req.Version})
+		if !ok {
+			continue
+		}
+
+		count := 0
+		for _, other := range reqs {
+			if satisfies(Package{Tag: tag}, other.Version) {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = tag, count
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"go/build"
+	"path/filepath"
+	"strings"
+)
+
+// addKnownRoot records the root of a repository so that all the import paths
+// it contains are resolved without further lookups
+func (d *Deep) addKnownRoot(root repoRoot) {
+	d.roots[root.root] = root
+}
+
+// addKnownPackages records the packages from the manifest or the lock as
+// repository roots since they are always stored by their root
+func (d *Deep) addKnownPackages(packages []Package) {
+	for _, pkg := range packages {
+		d.addKnownRoot(repoRoot{
+			root: pkg.Name,
+			vcs:  pkg.VCS,
+			url:  pkg.Source,
+		})
+	}
+}
+
+// knownRoot returns the known repository root which contains the import path
+func (d *Deep) knownRoot(importPath string) (repoRoot, bool) {
+	for path := importPath; path != ""; path = importPathDir(path) {
+		if root, ok := d.roots[path]; ok {
+			return root, true
+		}
+	}
+	return repoRoot{}, false
+}
+
+// importPathDir returns the parent of the import path or an empty string
+func importPathDir(importPath string) string {
+	idx := strings.LastIndex(importPath, "/")
+	if idx == -1 {
+		return ""
+	}
+	return importPath[:idx]
+}
+
+// rootFromDisk finds the repository root of the import path by looking for the
+// version control metadata in the vendor directory of the project and in GOPATH
+func (d *Deep) rootFromDisk(pwd, importPath string) (repoRoot, bool) {
+	bases := []string{d.vendorPath(pwd)}
+	for _, gopath := range filepath.SplitList(build.Default.GOPATH) {
+		bases = append(bases, filepath.Join(gopath, "src"))
+	}
+
+	for _, base := range bases {
+		for path := importPath; path != ""; path = importPathDir(path) {
+			dir := filepath.Join(base, filepath.FromSlash(path))
+			v, ok := vcsForDir(dir)
+			if !ok {
+				continue
+			}
+
+			return repoRoot{
+				root: path,
+				vcs:  v.name(),
+			}, true
+		}
+	}
+
+	return repoRoot{}, false
+}
+
+// repoRoot maps the import path to the root of the repository which holds it.
+// Already known roots are checked first, followed by the known code hosts, the
+// version control metadata found on disk and finally the go-import meta tags
+// and the well known vanity hosts.
+func (d *Deep) repoRoot(pwd, importPath string) (repoRoot, error) {
+	if root, ok := d.knownRoot(importPath); ok {
+		return root, nil
+	}
+
+	if root, ok := matchKnownHost(codeHosts, importPath); ok {
+		d.addKnownRoot(root)
+		return root, nil
+	}
+
+	if root, ok := d.rootFromDisk(pwd, importPath); ok {
+		d.addKnownRoot(root)
+		return root, nil
+	}
+
+	root, err := d.discoverRepoRoot(importPath)
+	if err != nil {
+		return repoRoot{}, err
+	}
+
+	d.addKnownRoot(root)
+	return root, nil
+}
+
+// rootPackage returns the package for the repository which holds the import
+// path. When the repository can't be found, the import path is used.
+func (d *Deep) rootPackage(pwd string, pkg Package) Package {
+	root, err := d.repoRoot(pwd, pkg.Name)
+	if err != nil {
+		d.log("Could not find the repository root of %s, using it as is: %v\n", pkg.Name, err)
+		return pkg
+	}
+
+	pkg.Name = root.root
+	return pkg
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+// importScanner finds the packages imported by the project by parsing its
+// Go files. Unlike go list, it doesn't need the dependencies to be present
+// so it works on a fresh checkout. The imports of the dependencies are found
+// while they are vendored. The imports are collected for all the OS/arch
+// combinations listed in the manifest, tests included.
+type importScanner struct {
+	log Logger
+}
+
+func (*importScanner) canUse(pwd, currentPkg string) bool {
+	return true
+}
+
+func (s *importScanner) packages(pwd, currentPkg string) ([]Package, error) {
+	// The manifest is optional but when present it lists the OS/arch combinations
+	manifest, _ := readManifest(pwd)
+	// The test dependencies of the project are always needed
+	imports, err := importsOf(pwd, manifestContexts(manifest), true)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Package
+	for _, imp := range imports {
+		result = append(result, Package{
+			Name:    imp,
+			Version: defaultVersion,
+		})
+	}
+
+	return result, nil
+}
+
+func newImportScanner(logger Logger) *importScanner {
+	return &importScanner{
+		log: logger,
+	}
+}
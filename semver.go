@@ -0,0 +1,370 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// semver holds a semantic version as defined by http://semver.org
+	semver struct {
+		major, minor, patch int64
+		pre                 []string
+		// parts holds the number of parts the version was written with,
+		// so that 1.2 can be told apart from 1.2.0
+		parts int
+		// wildcard is true when the last part was written as x, X or *
+		wildcard bool
+	}
+
+	// versionTerm is a single comparison such as >=1.2.3
+	versionTerm struct {
+		op  string
+		ver semver
+		// upper is the exclusive upper bound for the exclusion of partial
+		// versions, e.g. !=1.2 excludes everything from 1.2.0 up to 1.3.0
+		upper semver
+	}
+
+	// versionConstraint is a list of alternatives, separated by ||, where
+	// each alternative is satisfied only when all its terms are satisfied
+	versionConstraint [][]versionTerm
+)
+
+// parseSemver parses a version such as v1.2.3-beta.1+build. Minor and patch
+// parts are optional and they can be wildcards as well.
+func parseSemver(version string) (semver, error) {
+	v := semver{}
+	s := strings.TrimPrefix(strings.TrimPrefix(version, "v"), "V")
+	if idx := strings.Index(s, "+"); idx != -1 {
+		s = s[:idx]
+	}
+	if idx := strings.Index(s, "-"); idx != -1 {
+		if s[idx+1:] == "" {
+			return v, fmt.Errorf("invalid version: %s", version)
+		}
+		v.pre = strings.Split(s[idx+1:], ".")
+		s = s[:idx]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 || s == "" {
+		return v, fmt.Errorf("invalid version: %s", version)
+	}
+
+	values := []*int64{&v.major, &v.minor, &v.patch}
+	for idx, part := range parts {
+		if part == "x" || part == "X" || part == "*" {
+			if idx != len(parts)-1 || v.pre != nil {
+				return v, fmt.Errorf("invalid version: %s", version)
+			}
+			v.wildcard = true
+			break
+		}
+
+		value, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || value < 0 {
+			return v, fmt.Errorf("invalid version: %s", version)
+		}
+		*values[idx] = value
+		v.parts++
+	}
+
+	return v, nil
+}
+
+func (v semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+	if len(v.pre) > 0 {
+		s += "-" + strings.Join(v.pre, ".")
+	}
+	return s
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// comparePre compares the pre-release parts following the semver precedence rules
+func comparePre(a, b []string) int {
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	}
+
+	for idx := 0; idx < len(a) && idx < len(b); idx++ {
+		na, errA := strconv.ParseInt(a[idx], 10, 64)
+		nb, errB := strconv.ParseInt(b[idx], 10, 64)
+		switch {
+		case errA == nil && errB == nil:
+			if c := compareInt(na, nb); c != 0 {
+				return c
+			}
+		case errA == nil:
+			return -1
+		case errB == nil:
+			return 1
+		default:
+			if c := strings.Compare(a[idx], b[idx]); c != 0 {
+				return c
+			}
+		}
+	}
+
+	return compareInt(int64(len(a)), int64(len(b)))
+}
+
+// compare returns -1, 0 or 1 if v is lower, equal or greater than o
+func (v semver) compare(o semver) int {
+	if c := compareInt(v.major, o.major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.minor, o.minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.patch, o.patch); c != 0 {
+		return c
+	}
+	return comparePre(v.pre, o.pre)
+}
+
+// bump returns the first version which is not matched anymore by the
+// partial version, e.g. 1.2 becomes 1.3.0 and 1 becomes 2.0.0
+func (v semver) bump(parts int) semver {
+	switch parts {
+	case 1:
+		return semver{major: v.major + 1, parts: 3}
+	case 2:
+		return semver{major: v.major, minor: v.minor + 1, parts: 3}
+	}
+	return semver{major: v.major, minor: v.minor, patch: v.patch + 1, parts: 3}
+}
+
+// expandTerm turns the shorthand operators into plain comparisons
+func expandTerm(op string, v semver) []versionTerm {
+	switch op {
+	case "^":
+		// ^1.2.3 := >=1.2.3 <2.0.0, ^0.2.3 := >=0.2.3 <0.3.0, ^0.0.3 := >=0.0.3 <0.0.4
+		parts := 1
+		if v.major == 0 && v.parts > 1 {
+			parts = 2
+			if v.minor == 0 && v.parts > 2 {
+				parts = 3
+			}
+		}
+		if v.parts == 0 {
+			return nil
+		}
+		return []versionTerm{{op: ">=", ver: v}, {op: "<", ver: v.bump(parts)}}
+	case "~":
+		// ~1.2.3 := >=1.2.3 <1.3.0, ~1 := >=1.0.0 <2.0.0
+		parts := 2
+		if v.parts == 1 {
+			parts = 1
+		}
+		if v.parts == 0 {
+			return nil
+		}
+		return []versionTerm{{op: ">=", ver: v}, {op: "<", ver: v.bump(parts)}}
+	case "", "=":
+		// partial versions match everything with the same prefix
+		if v.parts == 0 {
+			return nil
+		}
+		if v.parts < 3 {
+			return []versionTerm{{op: ">=", ver: v}, {op: "<", ver: v.bump(v.parts)}}
+		}
+		return []versionTerm{{op: "=", ver: v}}
+	case "!=":
+		if v.parts < 3 {
+			return []versionTerm{{op: "!range", ver: v, upper: v.bump(v.parts)}}
+		}
+	case ">":
+		if v.parts < 3 {
+			return []versionTerm{{op: ">=", ver: v.bump(v.parts)}}
+		}
+	case "<=":
+		if v.parts < 3 {
+			return []versionTerm{{op: "<", ver: v.bump(v.parts)}}
+		}
+	}
+
+	return []versionTerm{{op: op, ver: v}}
+}
+
+// commitHashPattern matches the full and abbreviated commit hashes, which must
+// not be taken for a major version when made of digits only, e.g. 1234567
+var commitHashPattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// parseConstraint parses a version constraint. The supported constraints are:
+// exact versions (1.2.3, =1.2.3), partial versions (1.2, 1.2.x), comparisons
+// (>1.2, >=1.2, <2, <=1.4.2), exclusions (!=1.2.3), caret (^1.2), tilde (~1.2.3),
+// hyphen ranges (1.2 - 1.4) and the wildcard (*). Terms separated by spaces
+// or commas must all be satisfied while alternatives are separated by ||.
+func parseConstraint(constraint string) (versionConstraint, error) {
+	if commitHashPattern.MatchString(constraint) {
+		return nil, fmt.Errorf("invalid constraint, %s is a commit hash", constraint)
+	}
+
+	var result versionConstraint
+	for _, alternative := range strings.Split(constraint, "||") {
+		fields := strings.Fields(strings.Replace(alternative, ",", " ", -1))
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid constraint: %s", constraint)
+		}
+
+		var terms []versionTerm
+		for idx := 0; idx < len(fields); idx++ {
+			field := fields[idx]
+			if idx+2 < len(fields) && fields[idx+1] == "-" {
+				from, err := parseSemver(field)
+				if err != nil {
+					return nil, err
+				}
+				to, err := parseSemver(fields[idx+2])
+				if err != nil {
+					return nil, err
+				}
+				terms = append(terms, expandTerm(">=", from)...)
+				terms = append(terms, expandTerm("<=", to)...)
+				idx += 2
+				continue
+			}
+
+			op := ""
+			for _, prefix := range []string{">=", "<=", "!=", ">", "<", "=", "^", "~"} {
+				if strings.HasPrefix(field, prefix) {
+					op = prefix
+					break
+				}
+			}
+
+			value := strings.TrimPrefix(field, op)
+			if value == "" && idx+1 < len(fields) {
+				// allow a space between the operator and the version
+				idx++
+				value = fields[idx]
+			}
+
+			v, err := parseSemver(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid constraint: %s", constraint)
+			}
+			terms = append(terms, expandTerm(op, v)...)
+		}
+
+		result = append(result, terms)
+	}
+
+	return result, nil
+}
+
+func (t versionTerm) matches(v semver) bool {
+	c := v.compare(t.ver)
+	switch t.op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case "!range":
+		return c < 0 || v.compare(t.upper) >= 0
+	}
+	return false
+}
+
+// matches reports if the version satisfies the constraint. Pre-release versions
+// are matched only by the alternatives that mention a pre-release explicitly.
+func (c versionConstraint) matches(v semver) bool {
+	for _, terms := range c {
+		if c.matchesTerms(terms, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c versionConstraint) matchesTerms(terms []versionTerm, v semver) bool {
+	hasPre := false
+	for _, term := range terms {
+		if len(term.ver.pre) > 0 {
+			hasPre = true
+		}
+		if !term.matches(v) {
+			return false
+		}
+	}
+
+	return len(v.pre) == 0 || hasPre
+}
+
+// highestTag returns the highest tag which satisfies the constraint
+func (c versionConstraint) highestTag(tags []string) (string, bool) {
+	type tagVersion struct {
+		tag string
+		ver semver
+	}
+
+	var candidates []tagVersion
+	for _, tag := range tags {
+		v, err := parseSemver(tag)
+		if err != nil || v.parts != 3 || v.wildcard {
+			continue
+		}
+		if !c.matches(v) {
+			continue
+		}
+		candidates = append(candidates, tagVersion{tag: tag, ver: v})
+	}
+
+	if len(candidates) == 0 {
+		return "", false
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].ver.compare(candidates[j].ver) > 0
+	})
+
+	return candidates[0].tag, true
+}
+
+// isVersionConstraint reports if the version is a semver constraint rather
+// than a branch name or a commit hash
+func isVersionConstraint(version string) bool {
+	_, err := parseConstraint(version)
+	return err == nil
+}
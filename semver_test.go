@@ -0,0 +1,274 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"testing"
+)
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+		parts   int
+		err     bool
+	}{
+		{version: "1.2.3", want: "1.2.3", parts: 3},
+		{version: "v1.2.3", want: "1.2.3", parts: 3},
+		{version: "V1.2.3", want: "1.2.3", parts: 3},
+		{version: "1.2", want: "1.2.0", parts: 2},
+		{version: "1", want: "1.0.0", parts: 1},
+		{version: "1.2.x", want: "1.2.0", parts: 2},
+		{version: "1.X", want: "1.0.0", parts: 1},
+		{version: "*", want: "0.0.0", parts: 0},
+		{version: "1.2.3-beta.1", want: "1.2.3-beta.1", parts: 3},
+		{version: "1.2.3-beta.1+build.5", want: "1.2.3-beta.1", parts: 3},
+		{version: "1.2.3+build", want: "1.2.3", parts: 3},
+		{version: "", err: true},
+		{version: "v", err: true},
+		{version: "1.2.3.4", err: true},
+		{version: "1.x.3", err: true},
+		{version: "1.2.x-beta", err: true},
+		{version: "1.2.3-", err: true},
+		{version: "1.-2", err: true},
+		{version: "master", err: true},
+	}
+
+	for _, tt := range tests {
+		v, err := parseSemver(tt.version)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: got %s, want an error", tt.version, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.version, err)
+			continue
+		}
+		if v.String() != tt.want || v.parts != tt.parts {
+			t.Errorf("%q: got %s with %d parts, want %s with %d parts", tt.version, v, v.parts, tt.want, tt.parts)
+		}
+	}
+}
+
+func TestSemverPrecedence(t *testing.T) {
+	// Each version is lower than the next one, as listed by semver.org
+	versions := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.1.0",
+		"1.10.0",
+		"2.0.0",
+	}
+
+	for i := range versions {
+		for j := range versions {
+			a, _ := parseSemver(versions[i])
+			b, _ := parseSemver(versions[j])
+			want := compareInt(int64(i), int64(j))
+			if got := a.compare(b); got != want {
+				t.Errorf("compare(%s, %s): got %d, want %d", versions[i], versions[j], got, want)
+			}
+		}
+	}
+}
+
+func TestConstraintMatches(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"1.2.3", "1.2.3", true},
+		{"=1.2.3", "1.2.3", true},
+		{"1.2.3", "1.2.4", false},
+		{"v1.2.3", "1.2.3", true},
+
+		// partial versions and wildcards
+		{"1.2", "1.2.0", true},
+		{"1.2", "1.2.9", true},
+		{"1.2", "1.3.0", false},
+		{"1", "1.9.9", true},
+		{"1", "2.0.0", false},
+		{"1.2.x", "1.2.5", true},
+		{"1.2.x", "1.3.0", false},
+		{"1.x", "1.9.0", true},
+		{"*", "0.0.1", true},
+		{"*", "9.9.9", true},
+
+		// comparisons
+		{">1.2.3", "1.2.4", true},
+		{">1.2.3", "1.2.3", false},
+		{">1.2", "1.2.9", false},
+		{">1.2", "1.3.0", true},
+		{">=1.2", "1.2.0", true},
+		{">=1.2", "1.1.9", false},
+		{"<2", "1.9.9", true},
+		{"<2", "2.0.0", false},
+		{"<=1.4.2", "1.4.2", true},
+		{"<=1.4.2", "1.4.3", false},
+		{"<=1.4", "1.4.9", true},
+		{"<=1.4", "1.5.0", false},
+		{">= 1.2", "1.2.0", true},
+		{">=1.2 <1.4", "1.3.5", true},
+		{">=1.2 <1.4", "1.4.0", false},
+		{">=1.2, <1.4", "1.1.0", false},
+
+		// exclusions
+		{"!=1.2.3", "1.2.3", false},
+		{"!=1.2.3", "1.2.4", true},
+		{"!=1.2", "1.2.0", false},
+		{"!=1.2", "1.2.9", false},
+		{"!=1.2", "1.3.0", true},
+		{"!=1.2", "1.1.9", true},
+		{"!=1", "1.5.0", false},
+		{"!=1", "2.0.0", true},
+
+		// caret
+		{"^1.2.3", "1.2.3", true},
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "1.2.2", false},
+		{"^1.2.3", "2.0.0", false},
+		{"^1.2", "1.2.0", true},
+		{"^1", "1.9.9", true},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.0.3", "0.0.3", true},
+		{"^0.0.3", "0.0.4", false},
+		{"^0.0", "0.0.9", true},
+		{"^0.0", "0.1.0", false},
+		{"^0", "0.9.0", true},
+		{"^0", "1.0.0", false},
+
+		// tilde
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.2.2", false},
+		{"~1.2.3", "1.3.0", false},
+		{"~1.2", "1.2.0", true},
+		{"~1.2", "1.3.0", false},
+		{"~1", "1.9.0", true},
+		{"~1", "2.0.0", false},
+
+		// hyphen ranges
+		{"1.2 - 1.4", "1.2.0", true},
+		{"1.2 - 1.4", "1.4.9", true},
+		{"1.2 - 1.4", "1.5.0", false},
+		{"1.2 - 1.4", "1.1.9", false},
+		{"1.2.3 - 1.4.5", "1.4.5", true},
+		{"1.2.3 - 1.4.5", "1.4.6", false},
+
+		// alternatives
+		{"1.2 || >=2.1", "1.2.5", true},
+		{"1.2 || >=2.1", "2.0.0", false},
+		{"1.2 || >=2.1", "2.1.0", true},
+		{"^1 || ^3", "3.1.0", true},
+
+		// pre-releases are matched only when a pre-release is mentioned
+		{"^1.2.3", "1.3.0-beta", false},
+		{"*", "1.0.0-rc.1", false},
+		{">=1.2.3-beta", "1.2.3-beta.2", true},
+		{">=1.2.3-beta", "1.2.3-alpha", false},
+		{">=1.2.3-beta", "1.2.3", true},
+		{"<1.2.3", "1.2.3-beta", false},
+		{"<1.2.3 || >=1.2.3-beta <1.2.3", "1.2.3-rc.1", true},
+	}
+
+	for _, tt := range tests {
+		c, err := parseConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.constraint, err)
+			continue
+		}
+		v, err := parseSemver(tt.version)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.version, err)
+			continue
+		}
+		if got := c.matches(v); got != tt.want {
+			t.Errorf("%q matches %s: got %v, want %v", tt.constraint, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestIsVersionConstraint(t *testing.T) {
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{"1.2.3", true},
+		{"^1.2", true},
+		{"1.2 - 1.4", true},
+		{">=1.2 || 2", true},
+		{"*", true},
+		{"1", true},
+		{"123456", true},
+		{"master", false},
+		{"HEAD", false},
+		{"release-1.2", false},
+		{"1.2 ||", false},
+		{">=", false},
+		{"1 -", false},
+		// commit hashes, even the ones made of digits only
+		{"1234567", false},
+		{"abcdef1", false},
+		{"0123456789012345678901234567890123456789", false},
+		{"3e8b2a1c4d5f60718293a4b5c6d7e8f901234567", false},
+	}
+
+	for _, tt := range tests {
+		if got := isVersionConstraint(tt.version); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestHighestTag(t *testing.T) {
+	tags := []string{"v0.9.0", "v1.0.0", "v1.2.0", "v1.2.5", "v1.3.0-beta", "v2.0.0", "v2.1", "latest"}
+	tests := []struct {
+		constraint string
+		want       string
+		ok         bool
+	}{
+		{"*", "v2.0.0", true},
+		{"^1", "v1.2.5", true},
+		{"~1.2.0", "v1.2.5", true},
+		{"<1", "v0.9.0", true},
+		{">=1.3.0-beta <2", "v1.3.0-beta", true},
+		{"1.2.0", "v1.2.0", true},
+		// partial tags such as v2.1 are not releases
+		{"2.1", "", false},
+		{">3", "", false},
+	}
+
+	for _, tt := range tests {
+		c, err := parseConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.constraint, err)
+			continue
+		}
+		got, ok := c.highestTag(tags)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%q: got %q, %v, want %q, %v", tt.constraint, got, ok, tt.want, tt.ok)
+		}
+	}
+}
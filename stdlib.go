@@ -0,0 +1,153 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	stdlibOnce sync.Once
+	stdlib     map[string]struct{}
+)
+
+// stdlibSet returns the standard library packages of the active Go toolchain.
+// The static list is used when the toolchain can't be queried.
+func stdlibSet() map[string]struct{} {
+	stdlibOnce.Do(func() {
+		pkgs, err := toolchainStdlib()
+		if err != nil {
+			pkgs = stdlibPackages
+		}
+		stdlib = pkgs
+	})
+
+	return stdlib
+}
+
+// toolchainStdlib lists the standard library packages with go list std.
+// The result is cached for each Go version since it only changes between releases.
+func toolchainStdlib() (map[string]struct{}, error) {
+	version, err := goVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	// Development versions can change at any time so they are never cached
+	cacheFile := ""
+	if !strings.HasPrefix(version, "devel") {
+		cacheFile = filepath.Join(cacheDir(), "stdlib", version+".json")
+		if names, err := readStdlibCache(cacheFile); err == nil {
+			return stdlibMap(names), nil
+		}
+	}
+
+	output, err := exec.Command("go", "list", "std").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range strings.Split(string(output), "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.New("go list std returned no packages")
+	}
+
+	if cacheFile != "" {
+		// Failing to write the cache only means that go list runs again next time
+		_ = writeStdlibCache(cacheFile, names)
+	}
+
+	return stdlibMap(names), nil
+}
+
+// goVersion returns the version of the go command found in PATH, e.g. go1.9.2
+func goVersion() (string, error) {
+	output, err := exec.Command("go", "version").Output()
+	if err != nil {
+		return "", err
+	}
+
+	// The output looks like: go version go1.9.2 linux/amd64
+	fields := strings.Fields(string(output))
+	if len(fields) < 3 {
+		return "", errors.New("unexpected go version output: " + string(output))
+	}
+
+	return fields[2], nil
+}
+
+func readStdlibCache(path string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if err := json.Unmarshal(content, &names); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, errors.New("empty stdlib cache")
+	}
+
+	return names, nil
+}
+
+func writeStdlibCache(path string, names []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(names)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+func stdlibMap(names []string) map[string]struct{} {
+	result := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		result[name] = struct{}{}
+	}
+	return result
+}
+
+// cacheDir returns the directory where deep caches data between runs,
+// $XDG_CACHE_HOME/deep or ~/.cache/deep by default
+func cacheDir() string {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "deep")
+	}
+
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".cache", "deep")
+	}
+
+	return filepath.Join(os.TempDir(), "deep")
+}
@@ -0,0 +1,445 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The types of files which can be stripped from the vendored packages
+const (
+	stripVCS      = "vcs"
+	stripTests    = "test"
+	stripMain     = "main"
+	stripExamples = "examples"
+	stripTestdata = "testdata"
+	stripDocs     = "docs"
+	stripAssets   = "assets"
+	stripUnused   = "unused"
+
+	// stripAll and stripNone select all or none of the types of files in the manifest
+	stripAll  = "all"
+	stripNone = "none"
+)
+
+var (
+	allStripTypes = []string{stripVCS, stripTests, stripMain, stripExamples, stripTestdata, stripDocs, stripAssets, stripUnused}
+
+	// The assets are kept by default since packages may read them at run time
+	defaultStripTypes = []string{stripVCS, stripTests, stripMain, stripExamples, stripTestdata, stripDocs, stripUnused}
+
+	exampleDirs = map[string]struct{}{
+		"example":   {},
+		"examples":  {},
+		"_example":  {},
+		"_examples": {},
+	}
+
+	// Files such as .txt or .html are not considered documentation since
+	// packages often read them at run time
+	docExtensions = map[string]struct{}{
+		".md":       {},
+		".markdown": {},
+		".rst":      {},
+		".adoc":     {},
+	}
+
+	// docFiles are the prefixes of the documentation files without a
+	// documentation extension, e.g. README or CHANGELOG.txt
+	docFiles = []string{"README", "CHANGELOG", "CHANGES", "HISTORY"}
+
+	// sourceExtensions are the files that the go tool may need to build a package
+	sourceExtensions = map[string]struct{}{
+		".go":      {},
+		".s":       {},
+		".S":       {},
+		".c":       {},
+		".h":       {},
+		".cc":      {},
+		".cpp":     {},
+		".cxx":     {},
+		".hh":      {},
+		".hpp":     {},
+		".hxx":     {},
+		".m":       {},
+		".f":       {},
+		".F":       {},
+		".for":     {},
+		".f90":     {},
+		".swig":    {},
+		".swigcxx": {},
+		".syso":    {},
+	}
+
+	// legalFiles are the prefixes of the files which are never stripped
+	legalFiles = []string{"LICENSE", "LICENCE", "NOTICE", "COPYING", "AUTHORS", "CONTRIBUTORS", "PATENTS"}
+)
+
+// typesSet converts the list of types of files to a set, expanding all
+func (d *Deep) typesSet(types []string) map[string]struct{} {
+	result := map[string]struct{}{}
+	for _, typ := range types {
+		if typ == stripNone {
+			continue
+		}
+		// tests is the name used by the command line
+		if typ == "tests" {
+			typ = stripTests
+		}
+		if typ == stripAll {
+			for _, typ := range allStripTypes {
+				result[typ] = struct{}{}
+			}
+			continue
+		}
+		if !isStripType(typ) {
+			d.log("Unknown type of files to strip: %s\n", typ)
+			continue
+		}
+		result[typ] = struct{}{}
+	}
+	return result
+}
+
+func isStripType(typ string) bool {
+	for _, known := range allStripTypes {
+		if typ == known {
+			return true
+		}
+	}
+	return false
+}
+
+// projectStripTypes returns the types of files to strip from all the vendored
+// packages. The types of files to keep, when given, win over the strip list of
+// the project manifest which wins over the default types.
+func (d *Deep) projectStripTypes(keepTypes map[string]struct{}, manifest *Manifest) map[string]struct{} {
+	if keepTypes != nil {
+		result := map[string]struct{}{}
+		for _, typ := range allStripTypes {
+			if _, ok := keepTypes[typ]; !ok {
+				result[typ] = struct{}{}
+			}
+		}
+		return result
+	}
+
+	if manifest != nil && manifest.Strip != nil {
+		return d.typesSet(manifest.Strip)
+	}
+
+	return d.typesSet(defaultStripTypes)
+}
+
+// packageStripTypes returns the types of files to strip from the package, the
+// ones from its manifest entry or the ones for the whole project
+func (d *Deep) packageStripTypes(projectTypes map[string]struct{}, pkg Package) map[string]struct{} {
+	if pkg.Strip != nil {
+		return d.typesSet(pkg.Strip)
+	}
+
+	return projectTypes
+}
+
+// withoutType returns a copy of the types without the given one
+func withoutType(types map[string]struct{}, typ string) map[string]struct{} {
+	result := make(map[string]struct{}, len(types))
+	for t := range types {
+		if t != typ {
+			result[t] = struct{}{}
+		}
+	}
+	return result
+}
+
+func sortedTypes(types map[string]struct{}) []string {
+	result := make([]string, 0, len(types))
+	for typ := range types {
+		result = append(result, typ)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// stripPackages removes the types of files which are not needed from the
+// packages and records the types stripped from each of them. The packages
+// needed only by the test files are removed unless a package keeping its test
+// files needs them. With keepUnused, the packages fetched by this run keep
+// their unused packages.
+func (d *Deep) stripPackages(pwd, currentPkg string, keepTypes map[string]struct{}, keepUnused bool, packages []Package) []Package {
+	manifest, _ := readManifest(pwd)
+	projectTypes := d.projectStripTypes(keepTypes, manifest)
+
+	byType := map[string][]Package{}
+	// The project never has its test files stripped
+	keepsTests := map[string]bool{currentPkg: true}
+	for idx, pkg := range packages {
+		types := d.packageStripTypes(projectTypes, pkg)
+		if _, ok := types[stripUnused]; ok && keepUnused && pkg.fetched {
+			types = withoutType(types, stripUnused)
+		}
+		for typ := range types {
+			byType[typ] = append(byType[typ], pkg)
+		}
+		if _, ok := types[stripTests]; !ok && d.includesTests(pkg) {
+			keepsTests[pkg.Name] = true
+		}
+		packages[idx].Stripped = sortedTypes(types)
+	}
+
+	d.wipeVCS(pwd, byType[stripVCS])
+	d.wipeTestFiles(pwd, currentPkg, byType[stripTests])
+	d.wipeMainFiles(pwd, currentPkg, byType[stripMain])
+	// The embedded files are needed to build the packages, so they are kept
+	embedded := map[string]struct{}{}
+	contexts := manifestContexts(manifest)
+	for _, pkg := range packages {
+		for file := range embeddedFiles(d.vendoredPath(pwd, pkg), contexts) {
+			embedded[file] = struct{}{}
+		}
+	}
+
+	d.wipeDirs(pwd, byType[stripExamples], exampleDirs, embedded)
+	d.wipeDirs(pwd, byType[stripTestdata], map[string]struct{}{"testdata": {}}, embedded)
+	d.wipeFiles(pwd, byType[stripDocs], isDocFile, embedded)
+	d.wipeFiles(pwd, byType[stripAssets], isAssetFile, embedded)
+	// The unused packages are found last, once the test files are gone
+	saved, pruned := d.pruneUnused(pwd, byType[stripUnused])
+	if saved > 0 {
+		d.log("Removed the unused packages, saving %d bytes\n", saved)
+	}
+	d.removeEmptyDirs(pwd, packages)
+
+	// The directories pruned before are still missing from the packages kept,
+	// they're recorded so that the packages are vendored again once imported
+	lock, _ := readLock(pwd)
+	for idx, pkg := range packages {
+		var previous []string
+		if lock != nil && !pkg.fetched {
+			if locked, ok := lock.dependency(pkg.Name); ok {
+				previous = locked.Pruned
+			}
+		}
+		packages[idx].Pruned = mergePruned(previous, pruned[pkg.Name])
+	}
+
+	return d.pruneTestOnly(pwd, currentPkg, packages, keepsTests)
+}
+
+// isLegalFile checks if the file holds the license or other legal notices
+func isLegalFile(name string) bool {
+	name = strings.ToUpper(name)
+	for _, prefix := range legalFiles {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isDocFile(name string) bool {
+	if isLegalFile(name) {
+		return false
+	}
+
+	upper := strings.ToUpper(name)
+	for _, prefix := range docFiles {
+		if strings.HasPrefix(upper, prefix) {
+			return true
+		}
+	}
+
+	_, ok := docExtensions[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// embeddedFiles returns the files matched by the //go:embed patterns of the
+// packages, which are needed to build them whatever their type
+func embeddedFiles(root string, contexts []*build.Context) map[string]struct{} {
+	result := map[string]struct{}{}
+	walkPackages(root, contexts, nil, func(pkg *build.Package) {
+		patterns := append(append([]string(nil), pkg.EmbedPatterns...), pkg.TestEmbedPatterns...)
+		for _, pattern := range patterns {
+			pattern = strings.TrimPrefix(pattern, "all:")
+			matches, _ := filepath.Glob(filepath.Join(pkg.Dir, filepath.FromSlash(pattern)))
+			for _, match := range matches {
+				// Patterns matching a directory embed all the files it holds
+				filepath.Walk(match, func(path string, f os.FileInfo, err error) error {
+					if err == nil && !f.IsDir() {
+						result[path] = struct{}{}
+					}
+					return nil
+				})
+			}
+		}
+	})
+	return result
+}
+
+// holdsEmbedded checks if the path is, or holds, an embedded file
+func holdsEmbedded(path string, embedded map[string]struct{}) bool {
+	for file := range embedded {
+		if file == path || strings.HasPrefix(file, path+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAssetFile checks if the file is not needed to build the package
+func isAssetFile(name string) bool {
+	if isLegalFile(name) || isDocFile(name) || name == "go.mod" || name == "go.sum" {
+		return false
+	}
+
+	_, ok := sourceExtensions[filepath.Ext(name)]
+	return !ok
+}
+
+// wipeMainFiles removes the files of the main packages. The subdirectories
+// are left untouched since they may hold other packages.
+func (d *Deep) wipeMainFiles(pwd, currentPkg string, packages []Package) {
+	for _, pkg := range packages {
+		var mainDirs []string
+		err := filepath.Walk(d.vendoredPath(pwd, pkg), func(path string, f os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !f.IsDir() {
+				return nil
+			}
+			if skipImportsDir(f.Name()) && path != d.vendoredPath(pwd, pkg) {
+				return filepath.SkipDir
+			}
+
+			if p, err := build.ImportDir(path, 0); err == nil && p.IsCommand() {
+				mainDirs = append(mainDirs, path)
+			}
+			return nil
+		})
+		if err != nil {
+			d.log("Error while wiping main files: %v\n", err)
+			continue
+		}
+
+		for _, dir := range mainDirs {
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				d.log("Error while wiping main files: %v\n", err)
+				continue
+			}
+			for _, file := range files {
+				if file.IsDir() || isLegalFile(file.Name()) {
+					continue
+				}
+				if err := os.Remove(filepath.Join(dir, file.Name())); err != nil {
+					d.log("Error while wiping main files: %v\n", err)
+				}
+			}
+		}
+	}
+}
+
+// removeEmptyDirs removes the directories of the packages left empty after
+// stripping them
+func (d *Deep) removeEmptyDirs(pwd string, packages []Package) {
+	for _, pkg := range packages {
+		var dirs []string
+		err := filepath.Walk(d.vendoredPath(pwd, pkg), func(path string, f os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if f.IsDir() && path != d.vendoredPath(pwd, pkg) {
+				dirs = append(dirs, path)
+			}
+			return nil
+		})
+		if err != nil {
+			d.log("Error while removing empty directories: %v\n", err)
+			continue
+		}
+
+		// The deepest directories come last, so they are removed first
+		for idx := len(dirs) - 1; idx >= 0; idx-- {
+			if files, err := ioutil.ReadDir(dirs[idx]); err == nil && len(files) == 0 {
+				os.Remove(dirs[idx])
+			}
+		}
+	}
+}
+
+// wipeDirs removes the directories with the given names from the packages,
+// unless they hold embedded files
+func (d *Deep) wipeDirs(pwd string, packages []Package, names map[string]struct{}, embedded map[string]struct{}) {
+	for _, pkg := range packages {
+		err := filepath.Walk(d.vendoredPath(pwd, pkg), func(path string, f os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !f.IsDir() {
+				return nil
+			}
+			if _, ok := names[f.Name()]; !ok || path == d.vendoredPath(pwd, pkg) {
+				return nil
+			}
+			if holdsEmbedded(path, embedded) {
+				return filepath.SkipDir
+			}
+
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		})
+		if err != nil {
+			d.log("Error while wiping directories: %v\n", err)
+		}
+	}
+}
+
+// wipeFiles removes the files matching the given function from the packages,
+// except the embedded ones. The version control metadata is never looked into.
+func (d *Deep) wipeFiles(pwd string, packages []Package, matches func(name string) bool, embedded map[string]struct{}) {
+	for _, pkg := range packages {
+		err := filepath.Walk(d.vendoredPath(pwd, pkg), func(path string, f os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if f.IsDir() {
+				for _, vcsDir := range d.vcsDirs {
+					if f.Name() == vcsDir {
+						return filepath.SkipDir
+					}
+				}
+				return nil
+			}
+			if !matches(f.Name()) {
+				return nil
+			}
+			if _, ok := embedded[path]; ok {
+				return nil
+			}
+			return os.Remove(path)
+		})
+		if err != nil {
+			d.log("Error while wiping files: %v\n", err)
+		}
+	}
+}
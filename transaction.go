@@ -0,0 +1,212 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+)
+
+// stagingDirName is the directory, next to the vendor directory, where the new
+// vendor directory, manifest and lock files are prepared. It's ignored by the
+// go tool since it starts with a dot.
+const stagingDirName = ".deep_staging"
+
+// vendorPath returns the vendor directory being worked on, the one from the
+// staging directory while a run is in progress
+func (d *Deep) vendorPath(pwd string) string {
+	return filepath.Join(d.filesPath(pwd), "vendor")
+}
+
+// vendoredPath returns the directory of the vendored package
+func (d *Deep) vendoredPath(pwd string, pkg Package) string {
+	return filepath.Join(d.vendorPath(pwd), filepath.FromSlash(pkg.Name))
+}
+
+// filesPath returns the directory where the manifest and lock files are written,
+// the staging directory while a run is in progress
+func (d *Deep) filesPath(pwd string) string {
+	if d.staging != "" {
+		return d.staging
+	}
+	return pwd
+}
+
+// displayPath returns the path as it will be once the run is committed, since
+// the staging directory is removed when the run ends
+func (d *Deep) displayPath(path string) string {
+	if d.staging == "" {
+		return path
+	}
+
+	rel, err := filepath.Rel(d.staging, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+pathSeparatorString) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(d.staging), rel)
+}
+
+// displayOutput replaces the paths of the staging directory found in the
+// output of a command
+func (d *Deep) displayOutput(output string) string {
+	if d.staging == "" {
+		return output
+	}
+	return strings.Replace(output, d.staging+pathSeparatorString, filepath.Dir(d.staging)+pathSeparatorString, -1)
+}
+
+// begin starts a run by creating the staging directory with a copy of the
+// vendor directory. The files are hard linked when possible since they are
+// only ever removed, never changed in place. A staging directory left behind
+// by an interrupted run is removed first.
+func (d *Deep) begin(pwd string) error {
+	staging := filepath.Join(pwd, stagingDirName)
+	err := os.RemoveAll(staging)
+	if err != nil {
+		return err
+	}
+
+	vendorPath := filepath.Join(pwd, "vendor")
+	pathExists, err := d.pathExists(vendorPath)
+	if err != nil {
+		return err
+	}
+
+	if pathExists {
+		err = linkTree(vendorPath, filepath.Join(staging, "vendor"))
+	} else {
+		err = os.MkdirAll(filepath.Join(staging, "vendor"), 0755)
+	}
+	if err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+
+	d.staging = staging
+	return nil
+}
+
+// commit swaps the vendor directory with the one from the staging directory
+// and moves the manifest and lock files in place. Interrupts are delayed until
+// the swap is done.
+func (d *Deep) commit(pwd string) error {
+	if d.staging == "" {
+		return nil
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+
+	vendorPath := filepath.Join(pwd, "vendor")
+	oldPath := filepath.Join(d.staging, "vendor.old")
+	pathExists, err := d.pathExists(vendorPath)
+	if err != nil {
+		return err
+	}
+
+	if pathExists {
+		err = os.Rename(vendorPath, oldPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.Rename(filepath.Join(d.staging, "vendor"), vendorPath)
+	if err != nil {
+		if pathExists {
+			os.Rename(oldPath, vendorPath)
+		}
+		return err
+	}
+
+	for _, name := range []string{manifestFileName, lockFileName} {
+		err = os.Rename(filepath.Join(d.staging, name), filepath.Join(pwd, name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	d.rollback()
+	return nil
+}
+
+// rollback removes the staging directory, leaving the vendor directory and the
+// manifest and lock files untouched
+func (d *Deep) rollback() {
+	if d.staging == "" {
+		return
+	}
+
+	err := os.RemoveAll(d.staging)
+	if err != nil {
+		d.log("Error while removing the staging directory: %v\n", err)
+	}
+	d.staging = ""
+}
+
+// linkTree recreates the directory tree of src in dst, hard linking the files
+// or copying them when linking is not possible
+func linkTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case f.IsDir():
+			return os.MkdirAll(target, f.Mode().Perm())
+		case f.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+
+		if os.Link(path, target) == nil {
+			return nil
+		}
+		return copyFile(path, target, f.Mode().Perm())
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
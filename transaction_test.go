@@ -0,0 +1,119 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFailedRunRollback checks that a run failing once the staging directory
+// is built leaves the vendor directory, the manifest and the lock untouched
+func TestFailedRunRollback(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "deep-transaction")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lib := createTestRepo(t, dir, "lib", map[string]string{
+		"lib.go":      "package lib\n",
+		"lib_test.go": "package lib\n",
+		"README.md":   "lib\n",
+	})
+
+	pwd := filepath.Join(dir, "src", "example.com", "app")
+	writeTestFiles(t, pwd, map[string]string{
+		manifestFileName: `{"dependencies": [{"name": "example.com/lib", "version": "*", "source": "file://` + filepath.ToSlash(lib) + `"}]}`,
+		"main.go":        "package main\n\nimport _ \"example.com/lib\"\n\nfunc main() {}\n",
+	})
+
+	run := func() error {
+		d := New(func(string, ...interface{}) {})
+		d.SetCacheDir(filepath.Join(dir, "cache"))
+		d.input = strings.NewReader("")
+		return d.Run(context.Background(), pwd, "example.com/app", nil)
+	}
+
+	if err := run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The package is vendored again in the staging directory, whose files are
+	// hard linked to the vendor directory, while the other one can't be cloned
+	writeTestFiles(t, pwd, map[string]string{
+		manifestFileName: `{"dependencies": [
+	{"name": "example.com/lib", "version": "master", "source": "file://` + filepath.ToSlash(lib) + `"},
+	{"name": "example.com/missing", "version": "*", "vcs": "git", "source": "file://` + filepath.ToSlash(filepath.Join(dir, "missing")) + `"}
+]}`,
+	})
+	before := snapshotTree(t, pwd)
+	if _, ok := before["vendor/example.com/lib/lib.go"]; !ok {
+		t.Fatal("example.com/lib is not vendored")
+	}
+
+	if err := run(); !IsKind(err, ErrCheckoutFailed) {
+		t.Fatalf("got error %v, want %v", err, ErrCheckoutFailed)
+	}
+
+	after := snapshotTree(t, pwd)
+	for name, content := range before {
+		if got, ok := after[name]; !ok {
+			t.Errorf("%s was removed", name)
+		} else if got != content {
+			t.Errorf("%s: got %q, want %q", name, got, content)
+		}
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			t.Errorf("%s was added", name)
+		}
+	}
+}
+
+// snapshotTree returns the content of the files under dir, by their slash
+// separated path relative to it
+func snapshotTree(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+		if err != nil || f.IsDir() {
+			return err
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(name)] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
@@ -0,0 +1,461 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// vcs defines the operations needed from a version control system
+type vcs interface {
+	// name returns the name of the version control system, e.g. git
+	name() string
+	// metadataDir returns the directory holding the repository metadata, e.g. .git
+	metadataDir() string
+	// isRoot reports if the path is the root of a repository
+	isRoot(path string) bool
+	// ping checks if the url points to a repository
+	ping(ctx context.Context, url string) error
+	// clone clones the repository at url to path, writing its progress to out
+	clone(ctx context.Context, url, path string, out io.Writer) error
+	checkout(ctx context.Context, path, revision string) error
+	// tagRevision returns the revision to checkout for the tag, so that a tag is
+	// never mistaken for another kind of revision
+	tagRevision(tag string) string
+	// revision returns the identifier of the revision checked out
+	revision(path string) (string, error)
+	tags(path string) ([]string, error)
+}
+
+// cachingVCS is implemented by the version control systems which can keep a
+// mirror of the repositories in the shared cache
+type cachingVCS interface {
+	vcs
+	// isMirror reports if the path holds a mirror of a repository
+	isMirror(path string) bool
+	// mirror creates a mirror of the repository at url in path
+	mirror(ctx context.Context, url, path string, out io.Writer) error
+	// fetch updates the mirror at path from the repository at url
+	fetch(ctx context.Context, url, path string, out io.Writer) error
+	// hasRevision reports if the repository at path holds the revision
+	hasRevision(path, revision string) bool
+	// origin returns the url the repository at path was cloned from
+	origin(path string) (string, error)
+	// setOrigin changes the url the repository at path was cloned from
+	setOrigin(path, url string) error
+	// verify checks the integrity of the repository at path
+	verify(ctx context.Context, path string) error
+}
+
+type (
+	gitVCS        struct{}
+	mercurialVCS  struct{}
+	bazaarVCS     struct{}
+	subversionVCS struct{}
+)
+
+// vcsList holds the supported version control systems, in order of preference
+var vcsList = []vcs{
+	gitVCS{},
+	mercurialVCS{},
+	bazaarVCS{},
+	subversionVCS{},
+}
+
+// vcsByName returns the version control system with the given name
+func vcsByName(name string) (vcs, bool) {
+	for _, v := range vcsList {
+		if v.name() == name {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// vcsForDir returns the version control system of the repository at path
+func vcsForDir(path string) (vcs, bool) {
+	for _, v := range vcsList {
+		if v.isRoot(path) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// vcsForURL finds the version control system of the repository at url
+func vcsForURL(ctx context.Context, url string) (vcs, bool) {
+	for _, v := range vcsList {
+		if v.ping(ctx, url) == nil {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// runVCS runs the command in the directory and returns its output. The error
+// output of the command is part of the returned error. The command is killed
+// when the context is done.
+func runVCS(ctx context.Context, dir, name string, args ...string) ([]byte, error) {
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return output, fmt.Errorf("%s %s: %v %s", name, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// streamVCS runs the command in the directory writing its output and progress
+// to out. The command is killed when the context is done.
+func streamVCS(ctx context.Context, dir string, out io.Writer, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd.Run()
+}
+
+func hasMetadataDir(path, dir string) bool {
+	f, err := os.Stat(path + pathSeparatorString + dir)
+	return err == nil && f.IsDir()
+}
+
+func (gitVCS) name() string {
+	return "git"
+}
+
+func (gitVCS) metadataDir() string {
+	return ".git"
+}
+
+func (v gitVCS) isRoot(path string) bool {
+	return hasMetadataDir(path, v.metadataDir())
+}
+
+func (gitVCS) ping(ctx context.Context, url string) error {
+	_, err := runVCS(ctx, "", "git", "ls-remote", url, "HEAD")
+	return err
+}
+
+func (gitVCS) clone(ctx context.Context, url, path string, out io.Writer) error {
+	return streamVCS(ctx, "", out, "git", "clone", "-v", url, path)
+}
+
+func (gitVCS) checkout(ctx context.Context, path, revision string) error {
+	// HEAD is the checked out commit, which may be a tag, not the default branch
+	if revision == "HEAD" {
+		revision = "origin/HEAD"
+	}
+	_, err := runVCS(ctx, path, "git", "checkout", "-q", revision)
+	return err
+}
+
+func (gitVCS) tagRevision(tag string) string {
+	return "refs/tags/" + tag
+}
+
+func (gitVCS) revision(path string) (string, error) {
+	output, err := runVCS(context.Background(), path, "git", "rev-parse", "HEAD")
+	return strings.TrimSpace(string(output)), err
+}
+
+func (gitVCS) tags(path string) ([]string, error) {
+	output, err := runVCS(context.Background(), path, "git", "tag", "-l")
+	return strings.Fields(string(output)), err
+}
+
+func (gitVCS) isMirror(path string) bool {
+	f, err := os.Stat(path + pathSeparatorString + "objects")
+	return err == nil && f.IsDir() && !hasMetadataDir(path, ".git")
+}
+
+func (gitVCS) mirror(ctx context.Context, url, path string, out io.Writer) error {
+	return streamVCS(ctx, "", out, "git", "clone", "--mirror", url, path)
+}
+
+func (gitVCS) fetch(ctx context.Context, url, path string, out io.Writer) error {
+	_, err := runVCS(ctx, path, "git", "remote", "set-url", "origin", url)
+	if err != nil {
+		return err
+	}
+	return streamVCS(ctx, path, out, "git", "fetch", "--prune", "origin")
+}
+
+func (gitVCS) hasRevision(path, revision string) bool {
+	_, err := runVCS(context.Background(), path, "git", "cat-file", "-e", revision+"^{commit}")
+	return err == nil
+}
+
+func (gitVCS) origin(path string) (string, error) {
+	output, err := runVCS(context.Background(), path, "git", "config", "--get", "remote.origin.url")
+	return strings.TrimSpace(string(output)), err
+}
+
+func (gitVCS) setOrigin(path, url string) error {
+	_, err := runVCS(context.Background(), path, "git", "remote", "set-url", "origin", url)
+	return err
+}
+
+func (gitVCS) verify(ctx context.Context, path string) error {
+	_, err := runVCS(ctx, path, "git", "fsck", "--no-progress")
+	return err
+}
+
+func (mercurialVCS) name() string {
+	return "hg"
+}
+
+func (mercurialVCS) metadataDir() string {
+	return ".hg"
+}
+
+func (v mercurialVCS) isRoot(path string) bool {
+	return hasMetadataDir(path, v.metadataDir())
+}
+
+func (mercurialVCS) ping(ctx context.Context, url string) error {
+	_, err := runVCS(ctx, "", "hg", "identify", url)
+	return err
+}
+
+func (mercurialVCS) clone(ctx context.Context, url, path string, out io.Writer) error {
+	return streamVCS(ctx, "", out, "hg", "clone", "-v", url, path)
+}
+
+func (mercurialVCS) checkout(ctx context.Context, path, revision string) error {
+	if revision == "HEAD" {
+		revision = "default"
+	}
+	_, err := runVCS(ctx, path, "hg", "update", "-r", revision)
+	return err
+}
+
+// tagRevision returns a revset, as tags made of digits are otherwise taken
+// for revision numbers
+func (mercurialVCS) tagRevision(tag string) string {
+	return `tag("literal:` + tag + `")`
+}
+
+func (mercurialVCS) revision(path string) (string, error) {
+	output, err := runVCS(context.Background(), path, "hg", "log", "-r", ".", "--template", "{node}")
+	return strings.TrimSpace(string(output)), err
+}
+
+func (mercurialVCS) tags(path string) ([]string, error) {
+	output, err := runVCS(context.Background(), path, "hg", "tags", "-q")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, tag := range strings.Fields(string(output)) {
+		if tag != "tip" {
+			result = append(result, tag)
+		}
+	}
+	return result, nil
+}
+
+func (v mercurialVCS) isMirror(path string) bool {
+	return hasMetadataDir(path, v.metadataDir())
+}
+
+func (mercurialVCS) mirror(ctx context.Context, url, path string, out io.Writer) error {
+	return streamVCS(ctx, "", out, "hg", "clone", "-U", url, path)
+}
+
+func (mercurialVCS) fetch(ctx context.Context, url, path string, out io.Writer) error {
+	return streamVCS(ctx, path, out, "hg", "pull", url)
+}
+
+func (mercurialVCS) hasRevision(path, revision string) bool {
+	_, err := runVCS(context.Background(), path, "hg", "log", "-r", revision, "--template", "{node}")
+	return err == nil
+}
+
+func (mercurialVCS) origin(path string) (string, error) {
+	output, err := runVCS(context.Background(), path, "hg", "paths", "default")
+	return strings.TrimSpace(string(output)), err
+}
+
+// setOrigin points the default path of the repository to url, hg has no command for it
+func (mercurialVCS) setOrigin(path, url string) error {
+	hgrc := filepath.Join(path, ".hg", "hgrc")
+	return ioutil.WriteFile(hgrc, []byte("[paths]\ndefault = "+url+"\n"), 0644)
+}
+
+func (mercurialVCS) verify(ctx context.Context, path string) error {
+	_, err := runVCS(ctx, path, "hg", "verify", "-q")
+	return err
+}
+
+func (bazaarVCS) name() string {
+	return "bzr"
+}
+
+func (bazaarVCS) metadataDir() string {
+	return ".bzr"
+}
+
+func (v bazaarVCS) isRoot(path string) bool {
+	return hasMetadataDir(path, v.metadataDir())
+}
+
+func (bazaarVCS) ping(ctx context.Context, url string) error {
+	_, err := runVCS(ctx, "", "bzr", "info", url)
+	return err
+}
+
+func (bazaarVCS) clone(ctx context.Context, url, path string, out io.Writer) error {
+	return streamVCS(ctx, "", out, "bzr", "branch", url, path)
+}
+
+var bazaarRevno = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+
+func (bazaarVCS) checkout(ctx context.Context, path, revision string) error {
+	switch {
+	case revision == "HEAD":
+		revision = "last:1"
+	case strings.HasPrefix(revision, "tag:"):
+		// tags are given by tagRevision
+	case bazaarRevno.MatchString(revision):
+		// revision numbers are used as they are
+	case strings.Contains(revision, "@"):
+		// revision ids look like user@example.com-20170101120000-abcdef
+		revision = "revid:" + revision
+	default:
+		revision = "tag:" + revision
+	}
+
+	_, err := runVCS(ctx, path, "bzr", "update", "-r", revision)
+	return err
+}
+
+func (bazaarVCS) tagRevision(tag string) string {
+	return "tag:" + tag
+}
+
+func (bazaarVCS) revision(path string) (string, error) {
+	output, err := runVCS(context.Background(), path, "bzr", "revision-info", "--tree")
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 {
+		return "", fmt.Errorf("unexpected bzr revision-info output: %s", output)
+	}
+	return fields[1], nil
+}
+
+func (bazaarVCS) tags(path string) ([]string, error) {
+	output, err := runVCS(context.Background(), path, "bzr", "tags")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			result = append(result, fields[0])
+		}
+	}
+	return result, nil
+}
+
+func (subversionVCS) name() string {
+	return "svn"
+}
+
+func (subversionVCS) metadataDir() string {
+	return ".svn"
+}
+
+func (v subversionVCS) isRoot(path string) bool {
+	return hasMetadataDir(path, v.metadataDir())
+}
+
+func (subversionVCS) ping(ctx context.Context, url string) error {
+	_, err := runVCS(ctx, "", "svn", "info", url)
+	return err
+}
+
+func (subversionVCS) clone(ctx context.Context, url, path string, out io.Writer) error {
+	return streamVCS(ctx, "", out, "svn", "checkout", url, path)
+}
+
+var subversionRevno = regexp.MustCompile(`^r?[0-9]+$`)
+
+// checkout switches the working copy to the revision. Revisions can be revision
+// numbers, repository relative paths such as ^/tags/v1.0.0, as returned by
+// tagRevision, or pinned to a revision, such as ^/tags/v1.0.0@123, as returned
+// by revision. Any other name is taken from the tags directory.
+func (subversionVCS) checkout(ctx context.Context, path, revision string) error {
+	var err error
+	switch {
+	case revision == "HEAD":
+		_, err = runVCS(ctx, path, "svn", "update", "-r", "HEAD")
+	case subversionRevno.MatchString(revision):
+		_, err = runVCS(ctx, path, "svn", "update", "-r", strings.TrimPrefix(revision, "r"))
+	case strings.HasPrefix(revision, "^/"):
+		_, err = runVCS(ctx, path, "svn", "switch", "--ignore-ancestry", revision)
+	default:
+		_, err = runVCS(ctx, path, "svn", "switch", "--ignore-ancestry", "^/tags/"+revision)
+	}
+	return err
+}
+
+func (subversionVCS) tagRevision(tag string) string {
+	return "^/tags/" + tag
+}
+
+func (subversionVCS) revision(path string) (string, error) {
+	relativeURL, err := runVCS(context.Background(), path, "svn", "info", "--show-item", "relative-url")
+	if err != nil {
+		return "", err
+	}
+
+	revision, err := runVCS(context.Background(), path, "svn", "info", "--show-item", "revision")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(relativeURL)) + "@" + strings.TrimSpace(string(revision)), nil
+}
+
+func (subversionVCS) tags(path string) ([]string, error) {
+	output, err := runVCS(context.Background(), path, "svn", "ls", "^/tags")
+	if err != nil {
+		// repositories without the standard layout don't have tags
+		return nil, nil
+	}
+
+	var result []string
+	for _, tag := range strings.Fields(string(output)) {
+		result = append(result, strings.TrimSuffix(tag, "/"))
+	}
+	return result, nil
+}
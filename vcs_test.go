@@ -0,0 +1,313 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testTags are the tags of the first revision of the test repositories. The
+// tags made of digits must not be taken for revision numbers: they name no
+// revision in hg and bzr and the second revision in svn.
+var testTags = []string{"3", "3.0.0", "v1.0.0"}
+
+// vcsTests create a repository holding two revisions of file.txt, with the
+// first one tagged with testTags, and return the url to clone it from
+var vcsTests = []struct {
+	vcs    vcs
+	bins   []string
+	create func(t *testing.T, dir string) string
+}{
+	{
+		vcs:  gitVCS{},
+		bins: []string{"git"},
+		create: func(t *testing.T, dir string) string {
+			repo := filepath.Join(dir, "repo")
+			runTestCommand(t, dir, "git", "init", "-q", repo)
+			writeTestFile(t, repo, "1")
+			runTestCommand(t, repo, "git", "add", "file.txt")
+			runTestCommand(t, repo, "git", "commit", "-q", "-m", "first")
+			writeTestFile(t, repo, "2")
+			runTestCommand(t, repo, "git", "commit", "-q", "-a", "-m", "second")
+			for _, tag := range testTags {
+				runTestCommand(t, repo, "git", "tag", tag, "HEAD~1")
+			}
+			return "file://" + filepath.ToSlash(repo)
+		},
+	},
+	{
+		vcs:  mercurialVCS{},
+		bins: []string{"hg"},
+		create: func(t *testing.T, dir string) string {
+			repo := filepath.Join(dir, "repo")
+			runTestCommand(t, dir, "hg", "init", repo)
+			writeTestFile(t, repo, "1")
+			runTestCommand(t, repo, "hg", "add", "file.txt")
+			runTestCommand(t, repo, "hg", "commit", "-m", "first")
+			writeTestFile(t, repo, "2")
+			runTestCommand(t, repo, "hg", "commit", "-m", "second")
+			runTestCommand(t, repo, "hg", append([]string{"tag", "-r", "0"}, testTags...)...)
+			return "file://" + filepath.ToSlash(repo)
+		},
+	},
+	{
+		vcs:  bazaarVCS{},
+		bins: []string{"bzr"},
+		create: func(t *testing.T, dir string) string {
+			repo := filepath.Join(dir, "repo")
+			runTestCommand(t, dir, "bzr", "init", "-q", repo)
+			writeTestFile(t, repo, "1")
+			runTestCommand(t, repo, "bzr", "add", "-q", "file.txt")
+			runTestCommand(t, repo, "bzr", "commit", "-q", "-m", "first")
+			writeTestFile(t, repo, "2")
+			runTestCommand(t, repo, "bzr", "commit", "-q", "-m", "second")
+			for _, tag := range testTags {
+				runTestCommand(t, repo, "bzr", "tag", "-r", "1", tag)
+			}
+			return "file://" + filepath.ToSlash(repo)
+		},
+	},
+	{
+		vcs:  subversionVCS{},
+		bins: []string{"svn", "svnadmin"},
+		create: func(t *testing.T, dir string) string {
+			repo := filepath.Join(dir, "repo")
+			url := "file://" + filepath.ToSlash(repo)
+			runTestCommand(t, dir, "svnadmin", "create", repo)
+			runTestCommand(t, dir, "svn", "mkdir", "-q", "-m", "layout", url+"/trunk", url+"/tags")
+
+			wc := filepath.Join(dir, "wc")
+			runTestCommand(t, dir, "svn", "checkout", "-q", url+"/trunk", wc)
+			writeTestFile(t, wc, "1")
+			runTestCommand(t, wc, "svn", "add", "-q", "file.txt")
+			runTestCommand(t, wc, "svn", "commit", "-q", "-m", "first")
+			writeTestFile(t, wc, "2")
+			runTestCommand(t, wc, "svn", "commit", "-q", "-m", "second")
+			for _, tag := range testTags {
+				runTestCommand(t, dir, "svn", "copy", "-q", "-m", "tag", url+"/trunk@2", url+"/tags/"+tag)
+			}
+			return url + "/trunk"
+		},
+	},
+}
+
+func TestVCSRoundTrip(t *testing.T) {
+	for _, tt := range vcsTests {
+		tt := tt
+		t.Run(tt.vcs.name(), func(t *testing.T) {
+			for _, bin := range tt.bins {
+				if _, err := exec.LookPath(bin); err != nil {
+					t.Skipf("%s is not installed", bin)
+				}
+			}
+
+			dir, err := ioutil.TempDir("", "deep-vcs")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			// The commands must not depend on the configuration of the user
+			home := os.Getenv("HOME")
+			os.Setenv("HOME", dir)
+			defer os.Setenv("HOME", home)
+
+			url := tt.create(t, dir)
+			ctx := context.Background()
+
+			if err := tt.vcs.ping(ctx, url); err != nil {
+				t.Fatalf("ping: %v", err)
+			}
+
+			path := filepath.Join(dir, "clone")
+			out := &bytes.Buffer{}
+			if err := tt.vcs.clone(ctx, url, path, out); err != nil {
+				t.Fatalf("clone: %v\n%s", err, out)
+			}
+			if v, ok := vcsForDir(path); !ok || v.name() != tt.vcs.name() {
+				t.Fatalf("the clone is not detected as a %s repository", tt.vcs.name())
+			}
+
+			head, err := tt.vcs.revision(path)
+			if err != nil {
+				t.Fatalf("revision: %v", err)
+			}
+			assertTestFile(t, path, "2")
+
+			tags, err := tt.vcs.tags(path)
+			if err != nil {
+				t.Fatalf("tags: %v", err)
+			}
+			sort.Strings(tags)
+			if !reflect.DeepEqual(tags, testTags) {
+				t.Fatalf("tags: got %v, want %v", tags, testTags)
+			}
+
+			for _, tag := range testTags {
+				if err := tt.vcs.checkout(ctx, path, tt.vcs.tagRevision(tag)); err != nil {
+					t.Fatalf("checkout %s: %v", tag, err)
+				}
+				assertTestFile(t, path, "1")
+			}
+			tagged, err := tt.vcs.revision(path)
+			if err != nil {
+				t.Fatalf("revision: %v", err)
+			}
+			if tagged == head {
+				t.Fatalf("revision: got %s for both v1.0.0 and the latest revision", head)
+			}
+
+			if err := tt.vcs.checkout(ctx, path, tagged); err != nil {
+				t.Fatalf("checkout %s: %v", tagged, err)
+			}
+			assertTestFile(t, path, "1")
+
+			if err := tt.vcs.checkout(ctx, path, head); err != nil {
+				t.Fatalf("checkout %s: %v", head, err)
+			}
+			assertTestFile(t, path, "2")
+			if revision, _ := tt.vcs.revision(path); revision != head {
+				t.Fatalf("revision: got %s, want %s", revision, head)
+			}
+
+			if err := tt.vcs.checkout(ctx, path, "HEAD"); err != nil {
+				t.Fatalf("checkout HEAD: %v", err)
+			}
+			assertTestFile(t, path, "2")
+		})
+	}
+}
+
+// TestVendorRepository vendors the repository through the cache, when the
+// version control system supports it, for the kinds of versions of a manifest
+func TestVendorRepository(t *testing.T) {
+	for _, tt := range vcsTests {
+		tt := tt
+		t.Run(tt.vcs.name(), func(t *testing.T) {
+			for _, bin := range tt.bins {
+				if _, err := exec.LookPath(bin); err != nil {
+					t.Skipf("%s is not installed", bin)
+				}
+			}
+
+			dir, err := ioutil.TempDir("", "deep-vcs")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			home := os.Getenv("HOME")
+			os.Setenv("HOME", dir)
+			defer os.Setenv("HOME", home)
+
+			url := tt.create(t, dir)
+			pwd := filepath.Join(dir, "project")
+			d := New(func(string, ...interface{}) {})
+			d.SetCacheDir(filepath.Join(dir, "cache"))
+
+			vendor := func(pkg *Package) string {
+				if err := os.RemoveAll(d.vendorPath(pwd)); err != nil {
+					t.Fatal(err)
+				}
+				out := &bytes.Buffer{}
+				if err := d.vendorRepository(context.Background(), pwd, pkg, nil, out); err != nil {
+					t.Fatalf("%s: %v\n%s", pkg.Version, err, out)
+				}
+				if pkg.VCS != tt.vcs.name() {
+					t.Errorf("%s: got vcs %s, want %s", pkg.Version, pkg.VCS, tt.vcs.name())
+				}
+				revision, err := tt.vcs.revision(d.vendoredPath(pwd, *pkg))
+				if err != nil {
+					t.Fatalf("%s: revision: %v", pkg.Version, err)
+				}
+				return revision
+			}
+
+			tests := []struct {
+				version string
+				tag     string
+				content string
+			}{
+				{version: "^1", tag: "v1.0.0", content: "1"},
+				{version: "*", tag: "3.0.0", content: "1"},
+				{version: "HEAD", content: "2"},
+			}
+
+			tagged := ""
+			for _, test := range tests {
+				pkg := &Package{Name: "example.com/repo", Version: test.version, Source: url}
+				revision := vendor(pkg)
+				if pkg.Tag != test.tag {
+					t.Errorf("%s: got tag %q, want %q", test.version, pkg.Tag, test.tag)
+				}
+				assertTestFile(t, d.vendoredPath(pwd, *pkg), test.content)
+				if test.tag == "v1.0.0" {
+					tagged = revision
+				}
+			}
+
+			// The locked revision is checked out as it is
+			pkg := &Package{Name: "example.com/repo", Version: "^1", Source: url, CommitHash: tagged}
+			if revision := vendor(pkg); revision != tagged {
+				t.Errorf("got revision %s, want %s", revision, tagged)
+			}
+			assertTestFile(t, d.vendoredPath(pwd, *pkg), "1")
+
+			if c, ok := tt.vcs.(cachingVCS); ok && !c.isMirror(d.mirrorPath(c, pkg.Name)) {
+				t.Errorf("the repository is not mirrored in the cache")
+			}
+		})
+	}
+}
+
+func runTestCommand(t *testing.T, dir, name string, args ...string) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=deep", "GIT_AUTHOR_EMAIL=deep@example.com",
+		"GIT_COMMITTER_NAME=deep", "GIT_COMMITTER_EMAIL=deep@example.com",
+		"HGUSER=deep <deep@example.com>", "HGRCPATH=",
+		"BZR_EMAIL=deep <deep@example.com>",
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s %v: %v\n%s", name, args, err, output)
+	}
+}
+
+func writeTestFile(t *testing.T, dir, content string) {
+	err := ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte(content+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertTestFile(t *testing.T, dir, content string) {
+	got, err := ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content+"\n" {
+		t.Fatalf("file.txt: got %q, want %q", got, content+"\n")
+	}
+}
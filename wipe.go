@@ -0,0 +1,141 @@
+// Copyright 2017 Florin Pățan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deep
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// WipePolicy decides what happens to the packages which are already vendored
+// when they need to be vendored again
+type WipePolicy int
+
+const (
+	// WipeAsk asks the user before replacing each vendored package. When the
+	// input is not a terminal, WipeIfChanged is used instead.
+	WipeAsk WipePolicy = iota
+	// WipeAlways always replaces the vendored packages
+	WipeAlways
+	// WipeNever never replaces the vendored packages
+	WipeNever
+	// WipeIfChanged replaces the vendored packages which don't match the lock,
+	// either because another revision is needed or because the vendored tree
+	// is at another revision than the locked one
+	WipeIfChanged
+)
+
+// SetWipePolicy sets the policy for the packages which are already vendored
+func (d *Deep) SetWipePolicy(policy WipePolicy) {
+	d.wipePolicy = policy
+}
+
+// shouldReplace decides if the vendored package should be replaced, based on
+// the wipe policy
+func (d *Deep) shouldReplace(pwd string, pkg Package, lock *Lock) bool {
+	policy := d.wipePolicy
+	if policy == WipeAsk && !isTerminal(d.input) {
+		policy = WipeIfChanged
+	}
+
+	switch policy {
+	case WipeAlways:
+		return true
+	case WipeNever:
+		return false
+	case WipeIfChanged:
+		return d.vendoredChanged(pwd, pkg, lock)
+	}
+
+	return d.shouldWipePath(d.vendoredPath(pwd, pkg))
+}
+
+// vendoredChanged checks if the vendored package doesn't match the lock. The
+// vendored tree is compared against the locked digest, when there is one, and
+// against the locked commit when its version control metadata is still there.
+func (d *Deep) vendoredChanged(pwd string, pkg Package, lock *Lock) bool {
+	if lock == nil {
+		return true
+	}
+
+	// The packages moved from a nested vendor directory may only have a digest
+	locked, ok := lock.dependency(pkg.Name)
+	if !ok || (locked.CommitHash == "" && locked.Digest == "") {
+		return true
+	}
+
+	// The package is resolved again or pinned to another revision
+	if pkg.CommitHash != locked.CommitHash {
+		return true
+	}
+
+	if locked.Digest != "" {
+		files, err := d.packageFiles(pwd, pkg, d.packageRoots(pwd, lock.Dependencies))
+		if err != nil || treeDigest(files) != locked.Digest {
+			return true
+		}
+	}
+
+	vendoredPath := d.vendoredPath(pwd, pkg)
+	v, ok := vcsForDir(vendoredPath)
+	if !ok {
+		return false
+	}
+
+	revision, err := v.revision(vendoredPath)
+	return err != nil || revision != locked.CommitHash
+}
+
+// shouldWipePath asks the user if the path should be wiped. Yes is the default
+// answer and anything but yes or no is asked again, up to three times.
+func (d *Deep) shouldWipePath(path string) bool {
+	reader := bufio.NewReader(d.input)
+	for retries := 0; retries < 3; retries++ {
+		fmt.Printf("Do you want to wipe %s [Y/n] ", d.displayPath(path))
+		response, err := reader.ReadString('\n')
+		if err != nil && response == "" {
+			return false
+		}
+
+		switch strings.ToLower(strings.TrimSpace(response)) {
+		case "", "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+	}
+
+	return false
+}
+
+// isTerminal checks if the input is an interactive terminal. /dev/null being a
+// character device too, it's excluded explicitly.
+func isTerminal(input io.Reader) bool {
+	f, ok := input.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	devNull, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, devNull)
+}